@@ -1,6 +1,7 @@
 package serial
 
 import (
+	"os"
 	"testing"
 	"time"
 )
@@ -8,6 +9,11 @@ import (
 func TestConnection(t *testing.T) {
 	c0 := &Config{Name: "/dev/ttyUSB0", Baud: 115200}
 	c1 := &Config{Name: "/dev/ttyUSB1", Baud: 115200}
+	for _, c := range []*Config{c0, c1} {
+		if _, err := os.Stat(c.Name); err != nil {
+			t.Skipf("needs %s and %s wired together: %v", c0.Name, c1.Name, err)
+		}
+	}
 
 	s1, err := OpenPort(c0)
 	if err != nil {
@@ -26,7 +32,8 @@ func TestConnection(t *testing.T) {
 		for {
 			n, err := s2.Read(buf)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			readCount++
 			t.Logf("Read %v %v bytes: % 02x %s", readCount, n, buf[:n], buf[:n])
@@ -0,0 +1,249 @@
+package serial
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// Size of the serial buffer used when SetBuffer is not called.
+const DefaultBufferSize = 64 * 1024
+
+// OverflowPolicy selects what happens to received data when the serial buffer is full.
+type OverflowPolicy int
+
+const (
+	DropOldest OverflowPolicy = iota // Discard the oldest unread data
+	DropNewest                       // Discard the data being received
+	Block                            // Stop reading the port until data is read from the buffer
+)
+
+// SetBuffer sets the capacity in bytes of the serial buffer holding the
+// received data not read yet, and what to do with the data received when it is
+// full. A size of 0 selects DefaultBufferSize. The buffer is allocated when
+// the port is opened, so the settings apply to the next Open.
+//
+// With the Block policy, the port is not read while the buffer is full: the
+// data is held in the input queue of the port and, with flow control enabled,
+// the transmitter is paused.
+func (sp *SerialPort) SetBuffer(size int, policy OverflowPolicy) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.buffSize = size
+	sp.buffPolicy = policy
+}
+
+// Overflow returns the number of received bytes dropped because the serial
+// buffer was full since the port was opened.
+func (sp *SerialPort) Overflow() uint64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.buff == nil {
+		return 0
+	}
+	return sp.buff.dropped.Load()
+}
+
+// ring is a fixed capacity buffer with a single producer, the reader
+// goroutine, and consumers serialized by mu. It is not lock-free: the
+// positions are atomic so that the producer appends data without mu, but it
+// takes mu to drop the oldest data, which moves head under the consumers.
+// Consumers take the channel returned by wait before reading, so that data
+// written after the read wakes them up.
+type ring struct {
+	buf     []byte
+	policy  OverflowPolicy
+	head    atomic.Uint64 // Bytes consumed
+	tail    atomic.Uint64 // Bytes produced
+	dropped atomic.Uint64
+	mu      sync.Mutex
+	space   chan struct{}                 // signaled when data is consumed
+	ready   atomic.Pointer[chan struct{}] // closed and replaced when data is produced
+}
+
+func newRing(size int, policy OverflowPolicy) *ring {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	r := &ring{
+		buf:    make([]byte, size),
+		policy: policy,
+		space:  make(chan struct{}, 1),
+	}
+	ready := make(chan struct{})
+	r.ready.Store(&ready)
+	return r
+}
+
+// write appends p to the buffer, applying the overflow policy. It returns
+// false if done was closed while waiting for room.
+func (r *ring) write(p []byte, done <-chan struct{}) bool {
+	size := uint64(len(r.buf))
+	for len(p) > 0 {
+		tail := r.tail.Load()
+		free := size - (tail - r.head.Load())
+		if uint64(len(p)) > free {
+			switch r.policy {
+			case DropNewest:
+				r.dropped.Add(uint64(len(p)) - free)
+				p = p[:free]
+			case DropOldest:
+				if uint64(len(p)) > size {
+					r.dropped.Add(uint64(len(p)) - size)
+					p = p[uint64(len(p))-size:]
+				}
+				r.mu.Lock()
+				head := r.head.Load()
+				if free = size - (tail - head); uint64(len(p)) > free {
+					drop := uint64(len(p)) - free
+					r.head.Store(head + drop)
+					r.dropped.Add(drop)
+					free += drop
+				}
+				r.mu.Unlock()
+			case Block:
+				if free == 0 {
+					select {
+					case <-r.space:
+						continue
+					case <-done:
+						return false
+					}
+				}
+			}
+		}
+		n := uint64(len(p))
+		if n > free {
+			n = free
+		}
+		a, b := r.slices(tail, n)
+		copy(b, p[copy(a, p):n])
+		r.tail.Store(tail + n)
+		p = p[n:]
+
+		ready := make(chan struct{})
+		close(*r.ready.Swap(&ready))
+	}
+	return true
+}
+
+// wait returns a channel closed when data is written to the buffer.
+func (r *ring) wait() <-chan struct{} {
+	return *r.ready.Load()
+}
+
+// slices returns the n bytes of the buffer from the position pos.
+func (r *ring) slices(pos, n uint64) ([]byte, []byte) {
+	size := uint64(len(r.buf))
+	i := pos % size
+	if i+n <= size {
+		return r.buf[i : i+n], nil
+	}
+	return r.buf[i:], r.buf[:i+n-size]
+}
+
+// consume discards n bytes. Must be called with mu held.
+func (r *ring) consume(n uint64) {
+	r.head.Store(r.head.Load() + n)
+	select {
+	case r.space <- struct{}{}:
+	default:
+	}
+}
+
+// unread returns the data not read yet. Must be called with mu held.
+func (r *ring) unread() ([]byte, []byte) {
+	head := r.head.Load()
+	return r.slices(head, r.tail.Load()-head)
+}
+
+func (r *ring) read(p []byte) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, b := r.unread()
+	n := copy(p, a)
+	n += copy(p[n:], b)
+	r.consume(uint64(n))
+	return n
+}
+
+func (r *ring) readByte() (byte, bool) {
+	var c [1]byte
+	if r.read(c[:]) == 0 {
+		return 0, false
+	}
+	return c[0], true
+}
+
+// readLine returns the data up to the first terminator and the terminator, if any.
+func (r *ring) readLine(terms []string) ([]byte, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, b := r.unread()
+	i, term := findTerm(a, b, terms)
+	if i < 0 {
+		return nil, "", false
+	}
+	line := make([]byte, i)
+	copy(line[copy(line, a):], b)
+	r.consume(uint64(i + len(term)))
+	return line, term, true
+}
+
+// peek returns a copy of the data not read yet.
+func (r *ring) peek() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, b := r.unread()
+	return append(append([]byte(nil), a...), b...)
+}
+
+func (r *ring) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int(r.tail.Load() - r.head.Load())
+}
+
+func (r *ring) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.consume(r.tail.Load() - r.head.Load())
+}
+
+// findTerm returns the index of the earliest terminator in the concatenation
+// of a and b and the terminator, or -1. The longest terminator wins a tie.
+func findTerm(a, b []byte, terms []string) (int, string) {
+	first, term := -1, ""
+	for _, t := range terms {
+		i := index(a, b, []byte(t))
+		if i >= 0 && (first < 0 || i < first || i == first && len(t) > len(term)) {
+			first, term = i, t
+		}
+	}
+	return first, term
+}
+
+// index returns the index of the first sep in the concatenation of a and b, or -1.
+func index(a, b, sep []byte) int {
+	if i := bytes.Index(a, sep); i >= 0 {
+		return i
+	}
+	// Look for sep across the end of a and the start of b
+	if n := len(sep) - 1; n > 0 && len(b) > 0 {
+		start := len(a) - n
+		if start < 0 {
+			start = 0
+		}
+		if n > len(b) {
+			n = len(b)
+		}
+		joint := append(append([]byte(nil), a[start:]...), b[:n]...)
+		if i := bytes.Index(joint, sep); i >= 0 {
+			return start + i
+		}
+	}
+	if i := bytes.Index(b, sep); i >= 0 {
+		return len(a) + i
+	}
+	return -1
+}
@@ -0,0 +1,192 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(8, DropOldest)
+	r.write([]byte("abcde"), nil)
+	if got := string(r.peek()); got != "abcde" {
+		t.Errorf("peek() = %q", got)
+	}
+	buf := make([]byte, 3)
+	if n := r.read(buf); string(buf[:n]) != "abc" {
+		t.Errorf("read() = %q", buf[:n])
+	}
+	// Wrap around the end of the storage
+	r.write([]byte("fg\nhi"), nil)
+	if line, term, ok := r.readLine([]string{"\n"}); !ok || string(line) != "defg" || term != "\n" {
+		t.Errorf("readLine() = %q, %q, %t", line, term, ok)
+	}
+	if r.len() != 2 || r.dropped.Load() != 0 {
+		t.Errorf("len() = %d, dropped %d", r.len(), r.dropped.Load())
+	}
+	r.reset()
+	if r.len() != 0 {
+		t.Errorf("len() = %d after reset", r.len())
+	}
+}
+
+func TestFindTerm(t *testing.T) {
+	terms := []string{"\r", "\r\n", "> "}
+	for _, tt := range []struct {
+		a, b string
+		i    int
+		term string
+	}{
+		{"abc", "", -1, ""},
+		{"ab\r\ncd", "", 2, "\r\n"},
+		{"ab\rcd\r\n", "", 2, "\r"},
+		{"ab\r", "\ncd", 2, "\r\n"},
+		{"ab>", " cd\r", 2, "> "},
+		{"", "ab> ", 2, "> "},
+		{"ab", "cd\r", 4, "\r"},
+	} {
+		if i, term := findTerm([]byte(tt.a), []byte(tt.b), terms); i != tt.i || term != tt.term {
+			t.Errorf("findTerm(%q, %q) = %d, %q, want %d, %q", tt.a, tt.b, i, term, tt.i, tt.term)
+		}
+	}
+}
+
+func TestRingOverflow(t *testing.T) {
+	for _, tt := range []struct {
+		policy  OverflowPolicy
+		write   []string
+		want    string
+		dropped uint64
+	}{
+		{DropOldest, []string{"0123", "45678"}, "345678", 3},
+		{DropOldest, []string{"01", "0123456789"}, "456789", 6},
+		{DropNewest, []string{"0123", "45678"}, "012345", 3},
+		{DropNewest, []string{"0123456789"}, "012345", 4},
+	} {
+		r := newRing(6, tt.policy)
+		// Start in the middle of the storage
+		r.write([]byte("x"), nil)
+		r.readByte()
+		for _, w := range tt.write {
+			r.write([]byte(w), nil)
+		}
+		if got := string(r.peek()); got != tt.want || r.dropped.Load() != tt.dropped {
+			t.Errorf("policy %d: buffer holds %q, dropped %d, want %q, %d", tt.policy, got, r.dropped.Load(), tt.want, tt.dropped)
+		}
+	}
+}
+
+func TestRingBlock(t *testing.T) {
+	r := newRing(4, Block)
+	written := make(chan bool)
+	go func() { written <- r.write([]byte("012345"), nil) }()
+
+	select {
+	case <-written:
+		t.Fatal("write did not block on a full buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+	buf := make([]byte, 6)
+	n := r.read(buf)
+	if ok := <-written; !ok {
+		t.Error("write() = false")
+	}
+	n += r.read(buf[n:])
+	if string(buf[:n]) != "012345" || r.dropped.Load() != 0 {
+		t.Errorf("read %q, dropped %d", buf[:n], r.dropped.Load())
+	}
+
+	done := make(chan struct{})
+	go func() { written <- r.write([]byte("0123456789"), done) }()
+	close(done)
+	if ok := <-written; ok {
+		t.Error("write() = true after done")
+	}
+}
+
+func TestSerialPortOverflow(t *testing.T) {
+	src := &lineSource{line: []byte("0123456789abcdef\n")}
+	sp := newTestSerialPort()
+	sp.SetBuffer(1024, DropNewest)
+	if err := sp.OpenWith("source", src); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for sp.Overflow() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sp.Overflow() == 0 || sp.Available() != 1024 {
+		t.Errorf("Overflow() = %d with %d bytes buffered", sp.Overflow(), sp.Available())
+	}
+	if line, err := sp.ReadLine(); err != nil || line != "0123456789abcdef" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+}
+
+func BenchmarkRing(b *testing.B) {
+	r := newRing(DefaultBufferSize, Block)
+	chunk := make([]byte, 4096)
+	b.SetBytes(int64(len(chunk)))
+	go func() {
+		for i := 0; i < b.N; i++ {
+			r.write(chunk, nil)
+		}
+	}()
+	buf := make([]byte, 4096)
+	for total := 0; total < b.N*len(chunk); {
+		ready := r.wait()
+		n := r.read(buf)
+		if n == 0 {
+			<-ready
+		}
+		total += n
+	}
+}
+
+func BenchmarkReadLine(b *testing.B) {
+	src := &lineSource{line: append(bytes.Repeat([]byte("0123456789abcdef"), 4), '\n')}
+	sp := newTestSerialPort()
+	sp.SetBuffer(0, Block)
+	if err := sp.OpenWith("source", src); err != nil {
+		b.Fatal(err)
+	}
+	defer sp.Close()
+
+	b.SetBytes(int64(len(src.line)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sp.ReadLineContext(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// lineSource is a transport receiving the same line over and over, as fast as
+// it is read.
+type lineSource struct {
+	line   []byte
+	closed atomic.Bool
+}
+
+func (s *lineSource) Read(p []byte) (int, error) {
+	if s.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	n := 0
+	for n+len(s.line) <= len(p) {
+		n += copy(p[n:], s.line)
+	}
+	return n, nil
+}
+
+func (s *lineSource) Write(p []byte) (int, error) { return len(p), nil }
+
+func (s *lineSource) Close() error {
+	s.closed.Store(true)
+	return nil
+}
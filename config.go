@@ -0,0 +1,182 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Number of data bits used when Config.Size is left zero.
+const DefaultSize = 8
+
+// Flow control characters used when Config.XonChar and Config.XoffChar are left zero.
+const (
+	DefaultXonChar  byte = 0x11 // DC1, CTRL-Q
+	DefaultXoffChar byte = 0x13 // DC3, CTRL-S
+)
+
+/*******************************************************************************************
+*******************************   TYPE DEFINITIONS 	****************************************
+*******************************************************************************************/
+
+// Parity selects the parity bit sent after the data bits of every character.
+type Parity byte
+
+const (
+	ParityNone  Parity = 'N'
+	ParityOdd   Parity = 'O'
+	ParityEven  Parity = 'E'
+	ParityMark  Parity = 'M' // parity bit is always 1
+	ParitySpace Parity = 'S' // parity bit is always 0
+)
+
+// StopBits selects the number of stop bits sent after every character.
+type StopBits byte
+
+const (
+	Stop1     StopBits = 1
+	Stop1Half StopBits = 15
+	Stop2     StopBits = 2
+)
+
+// FlowControl selects how the transmitter is throttled by the receiver.
+type FlowControl byte
+
+const (
+	FlowNone     FlowControl = iota // No flow control
+	FlowHardware                    // RTS/CTS hardware handshake
+	FlowSoftware                    // XON/XOFF software handshake
+)
+
+// Config holds the parameters used to open a Port.
+//
+// Zero values select the defaults: 8 data bits, no parity, 1 stop bit,
+// no flow control and blocking reads.
+type Config struct {
+	Name        string // Device name or selector, see ResolvePort
+	Baud        int
+	ReadTimeout time.Duration // Total timeout
+
+	// InterByteTimeout ends a read once data was received and no further
+	// byte arrived for this duration, so that a read returns a whole burst
+	// of data. If 0, a read returns as soon as some data was received.
+	// ReadTimeout still bounds the whole read.
+	InterByteTimeout time.Duration
+
+	// Size is the number of data bits. If 0, DefaultSize is used.
+	Size byte
+
+	// Parity is the bit to use and defaults to ParityNone (no parity bit).
+	Parity Parity
+
+	// Number of stop bits to use. Default is 1 (1 stop bit).
+	StopBits StopBits
+
+	// Flow control used on the line. Default is FlowNone.
+	Flow FlowControl
+
+	// Characters used to resume and pause the transmission when Flow is
+	// FlowSoftware. Default are DefaultXonChar and DefaultXoffChar.
+	XonChar  byte
+	XoffChar byte
+
+	// LineErrors makes Read return ErrBreak or ErrFraming when a break or a
+	// character with a framing or parity error is received, instead of
+	// silently discarding it.
+	LineErrors bool
+
+	// Exclusive prevents other processes from opening the port while it is
+	// open (TIOCEXCL and flock). Opening a port held by another process
+	// fails with ErrPortBusy. Windows always opens ports exclusively.
+	Exclusive bool
+
+	// LockDir enables UUCP style lock files, e.g. /var/lock/LCK..ttyUSB0,
+	// created in the given directory. Lock files left by dead processes are
+	// removed. Ignored on Windows.
+	LockDir string
+}
+
+var (
+	ErrBadBaud     = errors.New("unsupported baud rate")
+	ErrBadSize     = errors.New("unsupported serial data size")
+	ErrBadStopBits = errors.New("unsupported stop bit setting")
+	ErrBadParity   = errors.New("unsupported parity setting")
+	ErrBadFlow     = errors.New("unsupported flow control setting")
+	ErrPortBusy    = errors.New("serial port is busy")
+)
+
+/*******************************************************************************************
+********************************   BASIC FUNCTIONS  ****************************************
+*******************************************************************************************/
+
+// OpenPort opens the serial port described by c and returns the raw Port.
+//
+// The returned Port is a plain io.ReadWriteCloser, it does not spawn any
+// goroutine nor log any traffic. Use SerialPort for buffered line access.
+func OpenPort(c *Config) (*Port, error) {
+	cfg := *c
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	name, err := ResolvePort(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Name = name
+	return openPort(&cfg)
+}
+
+/*******************************************************************************************
+******************************   PRIVATE FUNCTIONS  ****************************************
+*******************************************************************************************/
+
+// setDefaults replaces the zero fields having a default value.
+func (c *Config) setDefaults() {
+	if c.Size == 0 {
+		c.Size = DefaultSize
+	}
+	if c.Parity == 0 {
+		c.Parity = ParityNone
+	}
+	if c.StopBits == 0 {
+		c.StopBits = Stop1
+	}
+	if c.XonChar == 0 {
+		c.XonChar = DefaultXonChar
+	}
+	if c.XoffChar == 0 {
+		c.XoffChar = DefaultXoffChar
+	}
+}
+
+// validate checks that every field of a defaulted Config holds a known value.
+// The errors wrap ErrBadBaud, ErrBadSize, ErrBadParity, ErrBadStopBits or
+// ErrBadFlow.
+func (c *Config) validate() error {
+	if c.Baud <= 0 {
+		return fmt.Errorf("%w %d", ErrBadBaud, c.Baud)
+	}
+	if c.Size < 5 || c.Size > 8 {
+		return fmt.Errorf("%w: %d data bits", ErrBadSize, c.Size)
+	}
+	switch c.Parity {
+	case ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+	default:
+		return fmt.Errorf("%w %q", ErrBadParity, rune(c.Parity))
+	}
+	switch c.StopBits {
+	case Stop1, Stop1Half, Stop2:
+	default:
+		return fmt.Errorf("%w: %d", ErrBadStopBits, c.StopBits)
+	}
+	switch c.Flow {
+	case FlowNone, FlowHardware, FlowSoftware:
+	default:
+		return fmt.Errorf("%w: %d", ErrBadFlow, c.Flow)
+	}
+	if c.Flow == FlowSoftware && c.XonChar == c.XoffChar {
+		return fmt.Errorf("%w: XON and XOFF characters are both %#02x", ErrBadFlow, c.XonChar)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package serial
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpenPortBadConfig(t *testing.T) {
+	tests := []struct {
+		c   Config
+		err error
+	}{
+		{Config{Baud: -1}, ErrBadBaud},
+		{Config{Size: 4}, ErrBadSize},
+		{Config{Size: 9}, ErrBadSize},
+		{Config{Parity: 'X'}, ErrBadParity},
+		{Config{StopBits: 3}, ErrBadStopBits},
+		{Config{Flow: 7}, ErrBadFlow},
+		{Config{Flow: FlowSoftware, XonChar: 'q', XoffChar: 'q'}, ErrBadFlow},
+	}
+	for _, tt := range tests {
+		tt.c.Name = "/dev/null"
+		if tt.c.Baud == 0 {
+			tt.c.Baud = 9600
+		}
+		if _, err := OpenPort(&tt.c); !errors.Is(err, tt.err) {
+			t.Errorf("OpenPort(%+v) = %v, want %v", tt.c, err, tt.err)
+		}
+	}
+}
@@ -16,7 +16,7 @@ Example usage:
 
   func main() {
     sp := serial.New()
-    err := sp.Open("COM1", 9600)
+    err := sp.Open("COM1", serial.WithBaud(9600))
     if err != nil {
       panic(err)
     }
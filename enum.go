@@ -0,0 +1,18 @@
+package serial
+
+// PortInfo describes a serial port found by ListPorts.
+type PortInfo struct {
+	Name   string // Kernel name, e.g. "ttyUSB0"
+	Device string // Device node, e.g. "/dev/ttyUSB0"
+	Driver string // Kernel driver, e.g. "ftdi_sio"
+	ByID   string // Stable alias under /dev/serial/by-id, if any
+
+	// USB attributes, only valid when USB is set.
+	USB          bool
+	VID          uint16
+	PID          uint16
+	SerialNumber string
+	Manufacturer string
+	Product      string
+	Interface    uint8 // Interface number, telling apart the ports of multi-port adapters
+}
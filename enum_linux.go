@@ -0,0 +1,125 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ListPorts returns the serial ports present on the system, scanning /sys/class/tty.
+//
+// Virtual terminals, pseudo terminals and the legacy ports of the serial8250
+// driver registered whether or not a UART is present are not reported. Those
+// where a UART was detected are reported, as well as the UARTs of the other
+// platform drivers, e.g. ttymxc or ttyAMA.
+func ListPorts() ([]PortInfo, error) {
+	return listPorts("/sys", "/dev")
+}
+
+func listPorts(sysRoot, devRoot string) ([]PortInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(sysRoot, "class", "tty"))
+	if err != nil {
+		return nil, err
+	}
+	aliases := byIDAliases(devRoot)
+	ports := []PortInfo{}
+	for _, e := range entries {
+		info, ok := portInfo(sysRoot, devRoot, e.Name())
+		if !ok {
+			continue
+		}
+		info.ByID = aliases[info.Name]
+		ports = append(ports, info)
+	}
+	return ports, nil
+}
+
+// portInfo gathers the sysfs attributes of the tty name. ok is false when the
+// tty is not backed by a serial device.
+func portInfo(sysRoot, devRoot, name string) (info PortInfo, ok bool) {
+	dev, err := filepath.EvalSymlinks(filepath.Join(sysRoot, "class", "tty", name, "device"))
+	if err != nil {
+		return info, false
+	}
+	info = PortInfo{
+		Name:   name,
+		Device: filepath.Join(devRoot, name),
+		Driver: linkBase(filepath.Join(dev, "driver")),
+	}
+	if info.Driver == "serial8250" {
+		// The UART type is 0 (PORT_UNKNOWN) when none was found
+		typ, err := strconv.Atoi(readAttr(filepath.Join(sysRoot, "class", "tty", name), "type"))
+		if err != nil || typ == 0 {
+			return info, false
+		}
+	}
+	subsystem := linkBase(filepath.Join(dev, "subsystem"))
+
+	// USB serial converters hang below the interface, ACM devices are the interface
+	var intf string
+	switch subsystem {
+	case "usb-serial":
+		intf = filepath.Dir(dev)
+	case "usb":
+		intf = dev
+	default:
+		return info, true
+	}
+	usb := filepath.Dir(intf)
+	vid, err := strconv.ParseUint(readAttr(usb, "idVendor"), 16, 16)
+	if err != nil {
+		return info, true
+	}
+	pid, err := strconv.ParseUint(readAttr(usb, "idProduct"), 16, 16)
+	if err != nil {
+		return info, true
+	}
+	info.USB = true
+	info.VID = uint16(vid)
+	info.PID = uint16(pid)
+	info.SerialNumber = readAttr(usb, "serial")
+	info.Manufacturer = readAttr(usb, "manufacturer")
+	info.Product = readAttr(usb, "product")
+	if n, err := strconv.ParseUint(readAttr(intf, "bInterfaceNumber"), 16, 8); err == nil {
+		info.Interface = uint8(n)
+	}
+	return info, true
+}
+
+// byIDAliases maps the tty names to their /dev/serial/by-id links.
+func byIDAliases(devRoot string) map[string]string {
+	aliases := make(map[string]string)
+	dir := filepath.Join(devRoot, "serial", "by-id")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return aliases
+	}
+	for _, e := range entries {
+		link := filepath.Join(dir, e.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		aliases[filepath.Base(target)] = link
+	}
+	return aliases
+}
+
+// linkBase returns the last element of the symbolic link target of path.
+func linkBase(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// readAttr returns the trimmed content of the sysfs attribute name in dir.
+func readAttr(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,198 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSysfs describes the devices of a fake /sys and /dev tree.
+type fakeSysfs struct {
+	t   *testing.T
+	sys string
+	dev string
+}
+
+func newFakeSysfs(t *testing.T) *fakeSysfs {
+	root := t.TempDir()
+	fs := &fakeSysfs{t: t, sys: filepath.Join(root, "sys"), dev: filepath.Join(root, "dev")}
+	fs.mkdir(fs.sys, "class", "tty", "tty0")
+	fs.mkdir(fs.dev, "serial", "by-id")
+	return fs
+}
+
+func (fs *fakeSysfs) mkdir(elem ...string) string {
+	dir := filepath.Join(elem...)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fs.t.Fatal(err)
+	}
+	return dir
+}
+
+func (fs *fakeSysfs) write(dir string, attrs map[string]string) {
+	for name, value := range attrs {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			fs.t.Fatal(err)
+		}
+	}
+}
+
+func (fs *fakeSysfs) link(target, name string) {
+	if err := os.Symlink(target, name); err != nil {
+		fs.t.Fatal(err)
+	}
+}
+
+// addTTY registers the tty name backed by the sysfs device dir.
+func (fs *fakeSysfs) addTTY(name, dev, subsystem, driver string) {
+	fs.mkdir(dev)
+	fs.link(fs.mkdir(fs.sys, "bus", subsystem), filepath.Join(dev, "subsystem"))
+	fs.link(fs.mkdir(fs.sys, "bus", subsystem, "drivers", driver), filepath.Join(dev, "driver"))
+	fs.link(dev, filepath.Join(fs.mkdir(fs.sys, "class", "tty", name), "device"))
+}
+
+// addUSB registers a USB device with a single interface holding the tty name.
+func (fs *fakeSysfs) addUSB(name, bus, driver string, attrs map[string]string, byID string) {
+	usb := fs.mkdir(fs.sys, "devices", "pci0000:00", "usb1", bus)
+	fs.write(usb, attrs)
+	intf := fs.mkdir(usb, bus+":1.0")
+	fs.write(intf, map[string]string{"bInterfaceNumber": "00"})
+	if driver == "cdc_acm" {
+		fs.addTTY(name, intf, "usb", driver)
+	} else {
+		fs.addTTY(name, filepath.Join(intf, name), "usb-serial", driver)
+	}
+	fs.mkdir(fs.dev)
+	if err := os.WriteFile(filepath.Join(fs.dev, name), nil, 0644); err != nil {
+		fs.t.Fatal(err)
+	}
+	if byID != "" {
+		fs.link("../../"+name, filepath.Join(fs.dev, "serial", "by-id", byID))
+	}
+}
+
+func (fs *fakeSysfs) remove(name string) {
+	os.RemoveAll(filepath.Join(fs.sys, "class", "tty", name))
+	os.Remove(filepath.Join(fs.dev, name))
+}
+
+func TestListPorts(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.addUSB("ttyUSB0", "1-1", "ftdi_sio", map[string]string{
+		"idVendor":     "0403",
+		"idProduct":    "6001",
+		"serial":       "A12345",
+		"manufacturer": "FTDI",
+		"product":      "FT232R USB UART",
+	}, "usb-FTDI_FT232R_USB_UART_A12345-if00-port0")
+	fs.addUSB("ttyACM0", "1-2", "cdc_acm", map[string]string{
+		"idVendor":  "2341",
+		"idProduct": "0043",
+	}, "")
+	// Second port of a dual port adapter
+	intf := fs.mkdir(fs.sys, "devices", "pci0000:00", "usb1", "1-1", "1-1:1.1")
+	fs.write(intf, map[string]string{"bInterfaceNumber": "01"})
+	fs.addTTY("ttyUSB1", filepath.Join(intf, "ttyUSB1"), "usb-serial", "ftdi_sio")
+	// Legacy ports are only reported when a UART was detected
+	fs.addTTY("ttyS0", fs.mkdir(fs.sys, "devices", "platform", "serial8250", "tty", "ttyS0"), "platform", "serial8250")
+	fs.write(fs.mkdir(fs.sys, "class", "tty", "ttyS0"), map[string]string{"type": "0"})
+	fs.addTTY("ttyS1", fs.mkdir(fs.sys, "devices", "platform", "serial8250", "tty", "ttyS1"), "platform", "serial8250")
+	fs.write(fs.mkdir(fs.sys, "class", "tty", "ttyS1"), map[string]string{"type": "4"})
+	fs.addTTY("ttymxc0", fs.mkdir(fs.sys, "devices", "platform", "soc", "30860000.serial"), "platform", "imx-uart")
+	fs.addTTY("ttyS4", fs.mkdir(fs.sys, "devices", "pnp0", "00:04"), "pnp", "serial")
+
+	ports, err := listPorts(fs.sys, fs.dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PortInfo{
+		{
+			Name:   "ttyACM0",
+			Device: filepath.Join(fs.dev, "ttyACM0"),
+			Driver: "cdc_acm",
+			USB:    true,
+			VID:    0x2341,
+			PID:    0x0043,
+		},
+		{
+			Name:   "ttyS1",
+			Device: filepath.Join(fs.dev, "ttyS1"),
+			Driver: "serial8250",
+		},
+		{
+			Name:   "ttyS4",
+			Device: filepath.Join(fs.dev, "ttyS4"),
+			Driver: "serial",
+		},
+		{
+			Name:         "ttyUSB0",
+			Device:       filepath.Join(fs.dev, "ttyUSB0"),
+			Driver:       "ftdi_sio",
+			ByID:         filepath.Join(fs.dev, "serial", "by-id", "usb-FTDI_FT232R_USB_UART_A12345-if00-port0"),
+			USB:          true,
+			VID:          0x0403,
+			PID:          0x6001,
+			SerialNumber: "A12345",
+			Manufacturer: "FTDI",
+			Product:      "FT232R USB UART",
+		},
+		{
+			Name:         "ttyUSB1",
+			Device:       filepath.Join(fs.dev, "ttyUSB1"),
+			Driver:       "ftdi_sio",
+			USB:          true,
+			VID:          0x0403,
+			PID:          0x6001,
+			SerialNumber: "A12345",
+			Manufacturer: "FTDI",
+			Product:      "FT232R USB UART",
+			Interface:    1,
+		},
+		{
+			Name:   "ttymxc0",
+			Device: filepath.Join(fs.dev, "ttymxc0"),
+			Driver: "imx-uart",
+		},
+	}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("listPorts() =\n%+v\nwant\n%+v", ports, want)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	fs := newFakeSysfs(t)
+	fs.addUSB("ttyUSB0", "1-1", "ftdi_sio", map[string]string{"idVendor": "0403", "idProduct": "6001"}, "")
+
+	w, err := newWatcher(time.Millisecond*10, func() ([]PortInfo, error) {
+		return listPorts(fs.sys, fs.dev)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	expect := func(typ WatchEventType, name string) {
+		t.Helper()
+		select {
+		case e := <-w.Events:
+			if e.Type != typ || e.Port.Name != name {
+				t.Fatalf("got %s %s, want %s %s", e.Type, e.Port.Name, typ, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %s %s", typ, name)
+		}
+	}
+
+	expect(PortAdded, "ttyUSB0")
+	fs.addUSB("ttyACM0", "1-2", "cdc_acm", map[string]string{"idVendor": "2341", "idProduct": "0043"}, "")
+	expect(PortAdded, "ttyACM0")
+	fs.remove("ttyUSB0")
+	expect(PortRemoved, "ttyUSB0")
+
+	w.Close()
+	if _, ok := <-w.Events; ok {
+		t.Error("Events not closed by Close")
+	}
+}
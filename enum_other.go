@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+// ListPorts returns the serial ports present on the system.
+//
+// Port enumeration is only implemented on Linux.
+func ListPorts() ([]PortInfo, error) {
+	return nil, ErrNotSupported
+}
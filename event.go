@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	EventModem      EventType = iota // A modem status input line changed state
+	EventBreak                       // A break condition was received
+	EventFraming                     // A character with a framing or parity error was received
+	EventDisconnect                  // The device was lost, e.g. unplugged
+	EventReconnect                   // The device was reopened after being lost
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventModem:
+		return "modem"
+	case EventBreak:
+		return "break"
+	case EventFraming:
+		return "framing"
+	case EventDisconnect:
+		return "disconnect"
+	case EventReconnect:
+		return "reconnect"
+	}
+	return "unknown"
+}
+
+// Event is delivered to the channels registered with SerialPort.Notify.
+//
+// EventBreak and EventFraming are only raised when the port was opened with
+// Config.LineErrors set.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Port string // Name of the port raising the event
+
+	// For EventModem, the state of the lines after the change and the
+	// lines that changed state (true meaning changed).
+	Modem   ModemStatus
+	Changed ModemStatus
+
+	// For EventDisconnect, the error reported by the port.
+	Err error
+}
+
+// Notify causes the events raised by the serial port to be relayed to c.
+//
+// The serial port will not block sending to c: the caller must ensure that c has
+// sufficient buffer space to keep up with the expected event rate.
+// Modem line changes are only watched while at least one channel is registered.
+func (sp *SerialPort) Notify(c chan<- Event) {
+	sp.notifyMu.Lock()
+	defer sp.notifyMu.Unlock()
+	sp.notify = append(sp.notify, c)
+	sp.startModemWatch()
+}
+
+// Stop causes the serial port to stop relaying events to c.
+func (sp *SerialPort) Stop(c chan<- Event) {
+	sp.notifyMu.Lock()
+	defer sp.notifyMu.Unlock()
+	for i, n := range sp.notify {
+		if n == c {
+			sp.notify = append(sp.notify[:i], sp.notify[i+1:]...)
+			break
+		}
+	}
+}
+
+func (sp *SerialPort) emit(e Event) {
+	e.Time = time.Now()
+	sp.mu.Lock()
+	e.Port = sp.name
+	sp.mu.Unlock()
+	sp.notifyMu.Lock()
+	defer sp.notifyMu.Unlock()
+	for _, c := range sp.notify {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
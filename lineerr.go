@@ -0,0 +1,116 @@
+package serial
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Errors returned by Read when Config.LineErrors is set.
+var (
+	ErrBreak   = errors.New("break condition received")
+	ErrFraming = errors.New("framing or parity error received")
+)
+
+// breaker is implemented by ports able to send a break condition.
+type breaker interface {
+	SendBreak(d time.Duration) error
+}
+
+// SendBreak holds the transmit line in the spacing state for the duration d.
+func (sp *SerialPort) SendBreak(d time.Duration) error {
+	port, err := sp.current()
+	if err != nil {
+		return err
+	}
+	b, ok := port.(breaker)
+	if !ok {
+		return ErrNotSupported
+	}
+	sp.log(slog.LevelInfo, "Sending break", "dir", "tx", "duration", d)
+	return b.SendBreak(d)
+}
+
+// markReader decodes a stream received with the termios PARMRK flag set.
+//
+// A break is received as 0xff 0x00 0x00, a character with a framing or parity
+// error as 0xff 0x00 <char> and a 0xff data byte as 0xff 0xff. Markers are
+// reported as a single ErrBreak or ErrFraming returned by Read with no data.
+type markReader struct {
+	read func([]byte) (int, error)
+
+	mu   sync.Mutex // guards pend, not held while reading
+	pend []byte     // received bytes not decoded yet
+}
+
+func (m *markReader) Read(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if len(m.pend) > 0 {
+			n, err, more := m.decode(b)
+			if !more {
+				return n, err
+			}
+		}
+		// Nothing decodable yet, fetch more data
+		buf := make([]byte, len(b)+2)
+		m.mu.Unlock()
+		n, err := m.read(buf)
+		m.mu.Lock()
+		m.pend = append(m.pend, buf[:n]...)
+		if n == 0 {
+			return 0, err
+		}
+	}
+}
+
+// discard drops the received bytes not decoded yet.
+func (m *markReader) discard() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pend = nil
+}
+
+// decode moves the decoded data from pend into b. more is set when pend only
+// holds the beginning of a marker and further bytes have to be read. Must be
+// called with mu held.
+func (m *markReader) decode(b []byte) (n int, err error, more bool) {
+	i := 0
+	for i < len(m.pend) && n < len(b) {
+		c := m.pend[i]
+		if c != 0xff {
+			b[n] = c
+			n++
+			i++
+			continue
+		}
+		if i+1 >= len(m.pend) {
+			break
+		}
+		if m.pend[i+1] != 0x00 {
+			// 0xff 0xff is an escaped data byte
+			b[n] = m.pend[i+1]
+			n++
+			i += 2
+			continue
+		}
+		if i+2 >= len(m.pend) {
+			break
+		}
+		if n > 0 {
+			// Deliver the data preceding the marker first
+			break
+		}
+		if m.pend[i+2] == 0x00 {
+			err = ErrBreak
+		} else {
+			err = ErrFraming
+		}
+		i += 3
+		break
+	}
+	m.pend = m.pend[i:]
+	return n, err, n == 0 && err == nil
+}
@@ -0,0 +1,54 @@
+package serial
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMarkReader(t *testing.T) {
+	raw := []byte{'a', 0xff, 0xff, 'b', 0xff, 0x00, 0x00, 'c', 0xff, 0x00, 'x', 0xff, 0x00, 0x00}
+	want := "a\xffb<break>c<framing><break>"
+
+	// Feeding one byte at a time splits every marker across reads
+	for _, r := range []io.Reader{bytes.NewReader(raw), iotest.OneByteReader(bytes.NewReader(raw))} {
+		m := &markReader{read: r.Read}
+		var got []byte
+		buf := make([]byte, 16)
+		for {
+			n, err := m.Read(buf)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			switch err {
+			case nil:
+			case ErrBreak:
+				got = append(got, "<break>"...)
+			case ErrFraming:
+				got = append(got, "<framing>"...)
+			default:
+				t.Fatal(err)
+			}
+		}
+		if string(got) != want {
+			t.Errorf("decoded %q, want %q", got, want)
+		}
+	}
+}
+
+func TestMarkReaderFraming(t *testing.T) {
+	// A framing error on a port without parity, e.g. 8N1 at the wrong baud rate
+	m := &markReader{read: bytes.NewReader([]byte{'a', 0xff, 0x00, 'c', 'b'}).Read}
+	buf := make([]byte, 16)
+	for _, want := range []struct {
+		data string
+		err  error
+	}{{"a", nil}, {"", ErrFraming}, {"b", nil}} {
+		n, err := m.Read(buf)
+		if string(buf[:n]) != want.data || err != want.err {
+			t.Errorf("Read() = %q, %v, want %q, %v", buf[:n], err, want.data, want.err)
+		}
+	}
+}
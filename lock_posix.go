@@ -0,0 +1,77 @@
+//go:build !windows
+// +build !windows
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// uucpLock creates the UUCP style lock file of device in dir, e.g.
+// /var/lock/LCK..ttyUSB0, holding the PID of the process. A lock file left by
+// a process that no longer exists is removed. It returns the path of the lock
+// file, to be removed when the port is closed.
+func uucpLock(dir, device string) (string, error) {
+	name := strings.Replace(strings.TrimPrefix(device, "/dev/"), "/", "_", -1)
+	path := filepath.Join(dir, "LCK.."+name)
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%10d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return "", err
+			}
+			return path, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		if !staleLock(path) {
+			break
+		}
+		os.Remove(path)
+	}
+	return "", fmt.Errorf("%w: locked by %s", ErrPortBusy, path)
+}
+
+// staleLock reports whether the process owning the lock file path is gone.
+// Lock files that can not be parsed are considered alive.
+func staleLock(path string) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// flockExclusive takes an exclusive advisory lock on the open device fd.
+func flockExclusive(fd uintptr) error {
+	err := syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrPortBusy
+	}
+	return err
+}
+
+// busyError maps the error returned when opening a device held in exclusive
+// mode (TIOCEXCL) by another process to ErrPortBusy.
+func busyError(err error) error {
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("%w: %v", ErrPortBusy, err)
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package serial
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUUCPLock(t *testing.T) {
+	dir := t.TempDir()
+	path, err := uucpLock(dir, "/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "LCK..ttyUSB0"); path != want {
+		t.Fatalf("lock file %s, want %s", path, want)
+	}
+	b, _ := os.ReadFile(path)
+	if pid, _ := strconv.Atoi(strings.TrimSpace(string(b))); pid != os.Getpid() || len(b) != 11 {
+		t.Errorf("lock file holds %q", b)
+	}
+
+	// Held by a live process
+	if _, err := uucpLock(dir, "/dev/ttyUSB0"); !errors.Is(err, ErrPortBusy) {
+		t.Errorf("second lock error = %v, want ErrPortBusy", err)
+	}
+
+	// Left by a dead process
+	if err := os.WriteFile(path, []byte("2147483646\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uucpLock(dir, "/dev/ttyUSB0"); err != nil {
+		t.Errorf("stale lock not removed: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Package mock provides a fake serial port following an expectation script, to
+unit test code talking to serial devices without any hardware.
+
+Example usage:
+
+	p := mock.New()
+	p.Expect("AT\r\n").After(time.Millisecond * 20).Reply("OK\r\n")
+	p.Expect("AT+CSQ\r\n").ReplyChunks("+CSQ: 21,0\r\nOK\r\n", 4, time.Millisecond)
+	p.Expect("AT+CFUN=1,1\r\n").Reply("OK\r\n").Disconnect()
+
+	sp := serial.New()
+	sp.OpenWith("modem", p)
+	...
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+*/
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected is returned by Read and Write once the port was disconnected by the script.
+var ErrDisconnected = errors.New("mock: port disconnected")
+
+// Port is a fake serial port implementing io.ReadWriteCloser.
+//
+// Data written to the port is matched against the expected data of the steps
+// of the script, in order. Once the expected data of a step is written, its
+// replies are delivered to Read. Writing anything else is reported by Write
+// and Verify.
+type Port struct {
+	mu           sync.Mutex
+	steps        []*Step
+	written      []byte // written data not matched yet
+	rx           []byte // replied data not read yet
+	errs         []error
+	pending      []*Step // triggered steps waiting for their actions to run
+	wake         chan struct{}
+	readable     chan struct{} // closed when rx or the state changes
+	disconnected bool
+	closed       bool
+	done         chan struct{}
+}
+
+// Step is a step of the script of a Port, created by Port.Expect.
+type Step struct {
+	p       *Port
+	expect  []byte
+	actions []action
+	delay   time.Duration // delay of the next action
+}
+
+type action struct {
+	delay      time.Duration
+	data       []byte
+	disconnect bool
+}
+
+// New returns a port with an empty script.
+func New() *Port {
+	p := &Port{
+		wake:     make(chan struct{}, 1),
+		readable: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Expect appends a step to the script, triggered when data is written to the
+// port after the previous steps were triggered. An empty data triggers the
+// step as soon as it is reached, e.g. for an unsolicited message sent by the
+// device when the port is opened. A step without reply makes the reader of
+// the port time out.
+func (p *Port) Expect(data string) *Step {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &Step{p: p, expect: []byte(data)}
+	p.steps = append(p.steps, s)
+	return s
+}
+
+// After delays the next reply or disconnection of the step by d, counted from
+// the previous one or from the moment the step is triggered.
+func (s *Step) After(d time.Duration) *Step {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.delay += d
+	return s
+}
+
+// Reply makes data available to Read.
+func (s *Step) Reply(data string) *Step {
+	return s.add(action{data: []byte(data)})
+}
+
+// ReplyChunks makes data available to Read in chunks of size bytes spaced by gap.
+func (s *Step) ReplyChunks(data string, size int, gap time.Duration) *Step {
+	if size <= 0 {
+		size = 1
+	}
+	for i := 0; i < len(data); i += size {
+		if i > 0 {
+			s.After(gap)
+		}
+		end := i + size
+		if end > len(data) {
+			end = len(data)
+		}
+		s.Reply(data[i:end])
+	}
+	return s
+}
+
+// Garbage makes n bytes of line noise available to Read. The bytes are
+// pseudo random but always the same for a given n.
+func (s *Step) Garbage(n int) *Step {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return s.add(action{data: b})
+}
+
+// Disconnect makes the port behave like an unplugged device: Read returns the
+// data already replied then ErrDisconnected, Write returns ErrDisconnected.
+func (s *Step) Disconnect() *Step {
+	return s.add(action{disconnect: true})
+}
+
+func (s *Step) add(a action) *Step {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	a.delay = s.delay
+	s.delay = 0
+	s.actions = append(s.actions, a)
+	return s
+}
+
+// Read reads the data replied by the script, blocking until some is available.
+func (p *Port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.match()
+	for {
+		if len(p.rx) > 0 {
+			n := copy(b, p.rx)
+			p.rx = p.rx[n:]
+			return n, nil
+		}
+		if p.closed {
+			return 0, io.ErrClosedPipe
+		}
+		if p.disconnected {
+			return 0, ErrDisconnected
+		}
+		ch := p.readable
+		p.mu.Unlock()
+		<-ch
+		p.mu.Lock()
+	}
+}
+
+// Write matches b against the script. It returns an error if b is not expected.
+func (p *Port) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if p.disconnected {
+		return 0, ErrDisconnected
+	}
+	p.written = append(p.written, b...)
+	if err := p.match(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the port, pending replies are dropped.
+func (p *Port) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		p.changed()
+	}
+	return nil
+}
+
+// Verify returns an error if unexpected data was written to the port or if
+// steps of the script were not triggered.
+func (p *Port) Verify() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) > 0 {
+		return p.errs[0]
+	}
+	if len(p.steps) > 0 {
+		return fmt.Errorf("mock: %d expectations not met, next is %q", len(p.steps), p.steps[0].expect)
+	}
+	return nil
+}
+
+// match triggers the steps whose expected data was written. Must be called
+// with mu held.
+func (p *Port) match() error {
+	for len(p.steps) > 0 {
+		s := p.steps[0]
+		n := len(s.expect)
+		if n > len(p.written) {
+			n = len(p.written)
+		}
+		if !bytes.Equal(p.written[:n], s.expect[:n]) {
+			return p.unexpected(s.expect)
+		}
+		if n < len(s.expect) {
+			// Wait for the rest of the expected data
+			return nil
+		}
+		p.written = p.written[n:]
+		p.steps = p.steps[1:]
+		p.pending = append(p.pending, s)
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	if len(p.written) > 0 {
+		return p.unexpected(nil)
+	}
+	return nil
+}
+
+func (p *Port) unexpected(want []byte) error {
+	err := fmt.Errorf("mock: unexpected write %q, want %q", p.written, want)
+	p.errs = append(p.errs, err)
+	p.written = nil
+	return err
+}
+
+// changed wakes up the blocked readers. Must be called with mu held.
+func (p *Port) changed() {
+	close(p.readable)
+	p.readable = make(chan struct{})
+}
+
+// run performs the actions of the triggered steps, in order.
+func (p *Port) run() {
+	for {
+		select {
+		case <-p.wake:
+		case <-p.done:
+			return
+		}
+		for {
+			p.mu.Lock()
+			if len(p.pending) == 0 {
+				p.mu.Unlock()
+				break
+			}
+			s := p.pending[0]
+			p.pending = p.pending[1:]
+			actions := s.actions
+			p.mu.Unlock()
+
+			for _, a := range actions {
+				if a.delay > 0 {
+					select {
+					case <-time.After(a.delay):
+					case <-p.done:
+						return
+					}
+				}
+				p.mu.Lock()
+				if a.disconnect {
+					p.disconnected = true
+				}
+				p.rx = append(p.rx, a.data...)
+				p.changed()
+				p.mu.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package mock
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestScript(t *testing.T) {
+	p := New()
+	defer p.Close()
+	p.Expect("").Reply("RDY\r\n")
+	p.Expect("AT\r\n").After(20 * time.Millisecond).Reply("OK\r\n")
+	p.Expect("AT+CSQ\r\n").ReplyChunks("+CSQ: 21,0\r\n", 5, 10*time.Millisecond)
+	p.Expect("ATZ\r\n").Garbage(8).Reply("OK\r\n").Disconnect()
+
+	if got := readN(t, p, 5); got != "RDY\r\n" {
+		t.Errorf("unsolicited read %q", got)
+	}
+
+	start := time.Now()
+	write(t, p, "AT")
+	write(t, p, "\r\n")
+	if got := readN(t, p, 4); got != "OK\r\n" {
+		t.Errorf("AT read %q", got)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("reply after %v, want 20ms", d)
+	}
+
+	write(t, p, "AT+CSQ\r\n")
+	buf := make([]byte, 64)
+	n, err := p.Read(buf)
+	if err != nil || string(buf[:n]) != "+CSQ:" {
+		t.Errorf("first chunk = %q, %v", buf[:n], err)
+	}
+	if got := readN(t, p, 7); got != " 21,0\r\n" {
+		t.Errorf("remaining chunks %q", got)
+	}
+
+	write(t, p, "ATZ\r\n")
+	noise := make([]byte, 8)
+	if _, err := io.ReadFull(p, noise); err != nil {
+		t.Fatal(err)
+	}
+	again := New()
+	defer again.Close()
+	again.Expect("").Garbage(8)
+	if got := readN(t, again, 8); got != string(noise) {
+		t.Errorf("garbage is not deterministic: %x != %x", got, noise)
+	}
+	if got := readN(t, p, 4); got != "OK\r\n" {
+		t.Errorf("ATZ read %q", got)
+	}
+	if _, err := p.Read(buf); err != ErrDisconnected {
+		t.Errorf("Read() after disconnect = %v", err)
+	}
+	if _, err := p.Write([]byte("AT\r\n")); err != ErrDisconnected {
+		t.Errorf("Write() after disconnect = %v", err)
+	}
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnexpected(t *testing.T) {
+	p := New()
+	defer p.Close()
+	p.Expect("AT\r\n").Reply("OK\r\n")
+
+	if _, err := p.Write([]byte("ATI\r\n")); err == nil {
+		t.Error("unexpected write accepted")
+	}
+	if err := p.Verify(); err == nil {
+		t.Error("Verify() accepted an unexpected write")
+	}
+
+	p = New()
+	defer p.Close()
+	p.Expect("AT\r\n")
+	if err := p.Verify(); err == nil {
+		t.Error("Verify() accepted an unmet expectation")
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := New()
+	p.Expect("AT\r\n").After(time.Hour).Reply("OK\r\n")
+	write(t, p, "AT\r\n")
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Read(make([]byte, 4))
+		done <- err
+	}()
+	p.Close()
+	select {
+	case err := <-done:
+		if err != io.ErrClosedPipe {
+			t.Errorf("Read() = %v, want io.ErrClosedPipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock Read")
+	}
+}
+
+func write(t *testing.T, p *Port, s string) {
+	t.Helper()
+	if _, err := p.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readN(t *testing.T, p *Port, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(p, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
@@ -0,0 +1,165 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ErrNotSupported is returned when the underlying port does not implement an operation.
+var ErrNotSupported = errors.New("operation not supported by the port")
+
+// ModemStatus holds the state of the modem status input lines, true meaning asserted.
+type ModemStatus struct {
+	CTS bool // Clear To Send
+	DSR bool // Data Set Ready
+	DCD bool // Data Carrier Detect
+	RI  bool // Ring Indicator
+}
+
+// modemLines is implemented by ports giving access to the modem control lines.
+type modemLines interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	ModemStatus() (ModemStatus, error)
+}
+
+// modemStatuser is implemented by ports reporting the modem status lines.
+type modemStatuser interface {
+	ModemStatus() (ModemStatus, error)
+}
+
+// modemWaiter is implemented by ports able to wait for modem status changes.
+// WaitModemChange returns an error once the port is closed.
+type modemWaiter interface {
+	WaitModemChange() error
+}
+
+// Interval between two reads of the modem status lines of ports unable to
+// wait for their changes.
+const modemPollInterval = 10 * time.Millisecond
+
+// SetDTR drives the Data Terminal Ready output line.
+func (sp *SerialPort) SetDTR(on bool) error {
+	m, err := sp.modemLines()
+	if err != nil {
+		return err
+	}
+	sp.log(slog.LevelInfo, "Set DTR", "on", on)
+	return m.SetDTR(on)
+}
+
+// SetRTS drives the Request To Send output line.
+func (sp *SerialPort) SetRTS(on bool) error {
+	m, err := sp.modemLines()
+	if err != nil {
+		return err
+	}
+	sp.log(slog.LevelInfo, "Set RTS", "on", on)
+	return m.SetRTS(on)
+}
+
+// ModemStatus returns the current state of the CTS, DSR, DCD and RI input lines.
+func (sp *SerialPort) ModemStatus() (ModemStatus, error) {
+	m, err := sp.modemLines()
+	if err != nil {
+		return ModemStatus{}, err
+	}
+	return m.ModemStatus()
+}
+
+func (sp *SerialPort) modemLines() (modemLines, error) {
+	port, err := sp.current()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := port.(modemLines)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return m, nil
+}
+
+// startModemWatch starts watching the modem status lines if the port is open,
+// someone listens to the events and the port supports it. Must be called with
+// notifyMu held.
+func (sp *SerialPort) startModemWatch() {
+	if len(sp.notify) == 0 {
+		return
+	}
+	sp.mu.Lock()
+	port, done, open := sp.port, sp.done, sp.portIsOpen
+	sp.mu.Unlock()
+	m, ok := port.(modemStatuser)
+	if !open || !ok || sp.modemWatch == port {
+		return
+	}
+	// Changes are reported from now on
+	prev, err := m.ModemStatus()
+	if err != nil {
+		sp.log(slog.LevelWarn, "Unable to watch modem lines", "err", err)
+		return
+	}
+	sp.modemWatch = port
+	go sp.watchModem(port, m, prev, done)
+}
+
+// watchModem raises an EventModem for every modem status change observed on
+// port, starting from the status prev. The changes are waited for when the
+// port supports it, the lines are polled otherwise. It returns once the port
+// is closed or replaced.
+func (sp *SerialPort) watchModem(port io.ReadWriteCloser, m modemStatuser, prev ModemStatus, done chan struct{}) {
+	defer func() {
+		sp.notifyMu.Lock()
+		if sp.modemWatch == port {
+			sp.modemWatch = nil
+		}
+		sp.notifyMu.Unlock()
+	}()
+	var wait func() error
+	if w, ok := port.(modemWaiter); ok {
+		wait = w.WaitModemChange
+	} else {
+		tick := time.NewTicker(modemPollInterval)
+		defer tick.Stop()
+		wait = func() error {
+			select {
+			case <-done:
+				return os.ErrClosed
+			case <-tick.C:
+				return nil
+			}
+		}
+	}
+	for {
+		if err := wait(); err != nil {
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if cur, err := sp.current(); err != nil || cur != port {
+			return
+		}
+		st, err := m.ModemStatus()
+		if err != nil {
+			return
+		}
+		if st == prev {
+			continue
+		}
+		changed := ModemStatus{
+			CTS: st.CTS != prev.CTS,
+			DSR: st.DSR != prev.DSR,
+			DCD: st.DCD != prev.DCD,
+			RI:  st.RI != prev.RI,
+		}
+		sp.log(slog.LevelInfo, "Modem lines changed", "cts", st.CTS, "dsr", st.DSR, "dcd", st.DCD, "ri", st.RI)
+		sp.emit(Event{Type: EventModem, Modem: st, Changed: changed})
+		prev = st
+	}
+}
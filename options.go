@@ -0,0 +1,130 @@
+package serial
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Baud rate used by Open when WithBaud is not given.
+const DefaultBaud = 9600
+
+// Option configures a SerialPort, see New and Open. The port configuration
+// set by the options is checked as a whole once they are all applied.
+type Option func(*settings) error
+
+// settings collects the configuration set by options.
+type settings struct {
+	cfg        Config
+	terms      []string
+	buffSize   int
+	buffPolicy OverflowPolicy
+	logger     *slog.Logger
+	reconnect  *ReconnectPolicy
+}
+
+func (s *settings) apply(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithBaud sets the baud rate, DefaultBaud by default.
+func WithBaud(baud int) Option {
+	return func(s *settings) error {
+		s.cfg.Baud = baud
+		return nil
+	}
+}
+
+// WithFraming sets the number of data bits, the parity and the number of stop
+// bits, 8 data bits, no parity and 1 stop bit by default.
+func WithFraming(size byte, parity Parity, stop StopBits) Option {
+	return func(s *settings) error {
+		s.cfg.Size, s.cfg.Parity, s.cfg.StopBits = size, parity, stop
+		return nil
+	}
+}
+
+// WithFlowControl sets the flow control, FlowNone by default. XON/XOFF flow
+// control uses DefaultXonChar and DefaultXoffChar.
+func WithFlowControl(flow FlowControl) Option {
+	return func(s *settings) error {
+		s.cfg.Flow = flow
+		return nil
+	}
+}
+
+// WithReadTimeout sets how long ReadLine and Reader wait for data, one
+// second by default.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *settings) error {
+		if d <= 0 {
+			return fmt.Errorf("read timeout %s is not positive", d)
+		}
+		s.cfg.ReadTimeout = d
+		return nil
+	}
+}
+
+// WithInterByteTimeout ends the reads of the port once no byte was received
+// for d, see Config.InterByteTimeout.
+func WithInterByteTimeout(d time.Duration) Option {
+	return func(s *settings) error {
+		if d <= 0 {
+			return fmt.Errorf("inter-byte timeout %s is not positive", d)
+		}
+		s.cfg.InterByteTimeout = d
+		return nil
+	}
+}
+
+// WithEOL sets the terminators ending the lines read by ReadLine, see
+// SetTerminators. "\r\n" and "\n" are used by default.
+func WithEOL(terms ...string) Option {
+	return func(s *settings) error {
+		if err := checkTerms(terms); err != nil {
+			return err
+		}
+		s.terms = append([]string(nil), terms...)
+		return nil
+	}
+}
+
+// WithBuffer sets the size and overflow policy of the serial buffer, see SetBuffer.
+func WithBuffer(size int, policy OverflowPolicy) Option {
+	return func(s *settings) error {
+		if size < 0 {
+			return fmt.Errorf("buffer size %d is negative", size)
+		}
+		switch policy {
+		case DropOldest, DropNewest, Block:
+		default:
+			return fmt.Errorf("unknown overflow policy %d", policy)
+		}
+		s.buffSize, s.buffPolicy = size, policy
+		return nil
+	}
+}
+
+// WithLogger sets the logger, see NewWithLogger. A nil logger disables logging.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *settings) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithReconnect reopens the device when it is lost, see AutoReconnect.
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(s *settings) error {
+		if policy.MinDelay < 0 || policy.MaxDelay < 0 || policy.MaxAttempts < 0 {
+			return fmt.Errorf("reconnect policy %+v has negative values", policy)
+		}
+		s.reconnect = &policy
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package serial
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidation(t *testing.T) {
+	tests := []struct {
+		newOpts  []Option
+		openOpts []Option
+		err      error
+	}{
+		{[]Option{WithBaud(-1)}, nil, ErrBadBaud},
+		{nil, []Option{WithBaud(0)}, ErrBadBaud},
+		{nil, []Option{WithFraming(9, ParityNone, Stop1)}, ErrBadSize},
+		{nil, []Option{WithFraming(8, 'X', Stop1)}, ErrBadParity},
+		{nil, []Option{WithFraming(8, ParityNone, 3)}, ErrBadStopBits},
+		{[]Option{WithFlowControl(7)}, nil, ErrBadFlow},
+		{nil, []Option{WithReadTimeout(-time.Second)}, nil},
+		{nil, []Option{WithInterByteTimeout(0)}, nil},
+		{nil, []Option{WithEOL("")}, nil},
+		{nil, []Option{WithBuffer(-1, DropOldest)}, nil},
+		{nil, []Option{WithBuffer(0, 7)}, nil},
+		{nil, []Option{WithReconnect(ReconnectPolicy{MaxAttempts: -1})}, nil},
+	}
+	for i, tt := range tests {
+		sp := New(append(tt.newOpts, WithLogger(nil))...)
+		// The options are rejected before looking for the device
+		err := sp.Open("/nonexistent/tty", tt.openOpts...)
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) || errors.Is(err, ErrPortNotFound) {
+			t.Errorf("%d: Open() = %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+func TestOptionsApply(t *testing.T) {
+	policy := ReconnectPolicy{MinDelay: time.Second, MaxDelay: time.Minute}
+	sp := New(WithLogger(nil), WithEOL("\r"), WithBuffer(128, Block), WithReconnect(policy))
+	s := settings{cfg: sp.base}
+	if err := s.apply([]Option{WithBaud(115200), WithFraming(7, ParityEven, Stop2), WithFlowControl(FlowSoftware), WithReadTimeout(time.Minute), WithInterByteTimeout(time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Baud: 115200, ReadTimeout: time.Minute, InterByteTimeout: time.Millisecond, Size: 7, Parity: ParityEven, StopBits: Stop2, Flow: FlowSoftware}
+	if s.cfg != want {
+		t.Errorf("config %+v, want %+v", s.cfg, want)
+	}
+	if len(sp.terms) != 1 || sp.terms[0] != "\r" || sp.buffSize != 128 || sp.buffPolicy != Block || sp.logger != nil || *sp.reconnect != policy {
+		t.Errorf("terminators %q, buffer %d %d, logger %v, reconnect %+v", sp.terms, sp.buffSize, sp.buffPolicy, sp.logger, sp.reconnect)
+	}
+}
+
+func TestOptionsOpenFailure(t *testing.T) {
+	sp := New(WithLogger(nil))
+	want := settings{cfg: sp.base, terms: sp.terms, buffSize: sp.buffSize, buffPolicy: sp.buffPolicy}
+	err := sp.Open("/nonexistent/tty", WithBaud(115200), WithReadTimeout(time.Minute), WithEOL("\r"), WithBuffer(128, Block), WithReconnect(ReconnectPolicy{}))
+	if err == nil {
+		t.Fatal("Open() succeeded")
+	}
+	// Nothing given to the failed Open is kept
+	if sp.base != want.cfg || len(sp.terms) != len(want.terms) || sp.buffSize != want.buffSize || sp.buffPolicy != want.buffPolicy || sp.reconnect != nil {
+		t.Errorf("config %+v, terminators %q, buffer %d %d, reconnect %+v after failed Open", sp.base, sp.terms, sp.buffSize, sp.buffPolicy, sp.reconnect)
+	}
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	sp := New(WithLogger(nil), WithReadTimeout(3*time.Second), WithBaud(-5))
+	if err := sp.OpenWith("pipe", local); !errors.Is(err, ErrBadBaud) {
+		t.Fatalf("OpenWith() = %v, want %v", err, ErrBadBaud)
+	}
+
+	sp = New(WithLogger(nil), WithReadTimeout(50*time.Millisecond))
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	start := time.Now()
+	sp.ReadLine()
+	if d := time.Since(start); d < 50*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("ReadLine() returned after %s", d)
+	}
+}
@@ -0,0 +1,45 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// NewPTYPair creates a pseudo terminal and returns its master and slave ends
+// as two connected ports: data written to one is read from the other.
+//
+// The slave end is a regular tty configured like a serial port opened at
+// 115200 8N1 with blocking reads, which allows testing code using Port or
+// SerialPort without any hardware. Modem lines and breaks are not supported
+// on the master end.
+func NewPTYPair() (master, slave *Port, err error) {
+	m, err := os.OpenFile("/dev/ptmx", syscall.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			m.Close()
+		}
+	}()
+
+	if master, err = newPort(m); err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err = master.ioctl(syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	if err = master.ioctl(syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		return nil, nil, err
+	}
+
+	slave, err = OpenPort(&Config{Name: fmt.Sprintf("/dev/pts/%d", n), Baud: 115200})
+	if err != nil {
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,343 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func newPTYPair(t *testing.T) (master, slave *Port) {
+	master, slave, err := NewPTYPair()
+	if err != nil {
+		t.Skipf("no pseudo terminal support: %v", err)
+	}
+	t.Cleanup(func() {
+		slave.Close()
+		master.Close()
+	})
+	return master, slave
+}
+
+func TestPTYPair(t *testing.T) {
+	master, slave := newPTYPair(t)
+
+	if _, err := master.Write([]byte("hello\r\n\xff\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, slave, 9); got != "hello\r\n\xff\x00" {
+		t.Errorf("slave read %q", got)
+	}
+
+	if _, err := slave.Write([]byte("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, master, 6); got != "world\n" {
+		t.Errorf("master read %q", got)
+	}
+}
+
+func TestPTYSerialPort(t *testing.T) {
+	sp := newTestSerialPort()
+	t.Cleanup(func() { sp.Close() })
+	master, slave := newPTYPair(t)
+	if err := sp.OpenWith("pty", slave); err != nil {
+		t.Fatal(err)
+	}
+
+	master.Write([]byte("hello\r\n"))
+	if line, err := sp.ReadLine(); err != nil || line != "hello" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+
+	if err := sp.Println("AT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, master, 4); got != "AT\r\n" {
+		t.Errorf("master read %q", got)
+	}
+
+	master.Write([]byte("noise\r\nOK\r\n"))
+	if match, err := sp.WaitForRegexTimeout("O.", time.Second); err != nil || match != "OK" {
+		t.Errorf("WaitForRegexTimeout() = %q, %v", match, err)
+	}
+}
+
+// TestPTYConcurrentUse is mostly useful with -race.
+func TestPTYConcurrentUse(t *testing.T) {
+	for _, lineErrors := range []bool{false, true} {
+		t.Run(fmt.Sprintf("LineErrors=%v", lineErrors), func(t *testing.T) {
+			testPTYConcurrentUse(t, lineErrors)
+		})
+	}
+}
+
+func testPTYConcurrentUse(t *testing.T, lineErrors bool) {
+	sp := newTestSerialPort()
+	t.Cleanup(func() { sp.Close() })
+	master, slave := newPTYPair(t)
+	if lineErrors {
+		// Received data goes through the PARMRK decoder
+		if err := sp.OpenConfig(&Config{Name: slave.f.Name(), Baud: 115200, LineErrors: true}); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := sp.OpenWith("pty", slave); err != nil {
+		t.Fatal(err)
+	}
+
+	const lines = 100
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		// Consume the commands without leaving a reader blocked on master
+		defer wg.Done()
+		var want bytes.Buffer
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(&want, "AT+LINE=%d\r\n", i)
+		}
+		got := make([]byte, want.Len())
+		if _, err := io.ReadFull(master, got); err != nil || string(got) != want.String() {
+			t.Errorf("master read %q, %v", got, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(master, "line %d\r\n", i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < lines; i++ {
+			if err := sp.Printf("AT+LINE=%d\r\n", i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < lines; i++ {
+		want := fmt.Sprintf("line %d", i)
+		if line, err := sp.ReadLine(); err != nil || line != want {
+			t.Fatalf("ReadLine() = %q, %v, want %q", line, err, want)
+		}
+	}
+	wg.Wait()
+
+	// Flushing while data is received discards it without racing the reader
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(master, "noise %d\xff\xff\r\n", i)
+		}
+	}()
+	for i := 0; i < lines; i++ {
+		if err := sp.ResetBuffer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+	sp.ResetBuffer()
+	master.Write([]byte("OK\r\n"))
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Errorf("ReadLine() = %q, %v after ResetBuffer", line, err)
+	}
+}
+
+func TestPTYOpenOptions(t *testing.T) {
+	sp := New(WithLogger(nil), WithEOL("\r"))
+	t.Cleanup(func() { sp.Close() })
+	master, slave := newPTYPair(t)
+
+	if err := sp.Open(slave.f.Name(), WithBaud(57600), WithReadTimeout(100*time.Millisecond), WithBuffer(16, DropOldest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Open(slave.f.Name()); err == nil {
+		t.Error("port opened twice")
+	}
+	master.Write([]byte("0123456789abcdef\rOK\r"))
+	for deadline := time.Now().Add(time.Second); sp.Overflow() < 4 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if sp.Overflow() != 4 {
+		t.Errorf("Overflow() = %d, want 4", sp.Overflow())
+	}
+	if line, err := sp.ReadLine(); err != nil || line != "456789abcdef" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+
+	// The options of a successful Open are kept for the next one
+	sp.Close()
+	if err := sp.Open(slave.f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if sp.baud != 57600 || sp.readTimeout != 100*time.Millisecond || sp.buffSize != 16 {
+		t.Errorf("reopened at %d bauds, read timeout %s, buffer %d", sp.baud, sp.readTimeout, sp.buffSize)
+	}
+}
+
+func TestPTYReadTimeouts(t *testing.T) {
+	master, slave := newPTYPair(t)
+	p, err := OpenPort(&Config{Name: slave.f.Name(), Baud: 115200, ReadTimeout: 300 * time.Millisecond, InterByteTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	buf := make([]byte, 64)
+
+	// Nothing received, the total timeout expires
+	start := time.Now()
+	if n, err := p.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read() = %d, %v, want EOF", n, err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond || d > 2*time.Second {
+		t.Errorf("read timed out after %s", d)
+	}
+
+	// A burst is read at once, up to the first gap
+	master.Write([]byte("abc"))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		master.Write([]byte("def"))
+	}()
+	if n, err := p.Read(buf); err != nil || string(buf[:n]) != "abcdef" {
+		t.Errorf("Read() = %q, %v, want abcdef", buf[:n], err)
+	}
+
+	// The total timeout bounds a read while data keeps coming
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				master.Write([]byte("x"))
+			}
+		}
+	}()
+	start = time.Now()
+	n, err := p.Read(buf)
+	d := time.Since(start)
+	close(stop)
+	<-done
+	if err != nil || n == 0 || d < 250*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Read() = %d, %v after %s", n, err, d)
+	}
+}
+
+func TestPTYDeadlines(t *testing.T) {
+	master, slave := newPTYPair(t)
+	buf := make([]byte, 64)
+
+	slave.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := slave.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() = %v, want deadline exceeded", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > 2*time.Second {
+		t.Errorf("read deadline expired after %s", d)
+	}
+
+	// Moving the deadline interrupts a pending read
+	slave.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		slave.SetReadDeadline(time.Unix(1, 0))
+	}()
+	if _, err := slave.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() = %v, want deadline exceeded", err)
+	}
+	slave.SetReadDeadline(time.Time{})
+	master.Write([]byte("ok"))
+	if got := readFull(t, slave, 2); got != "ok" {
+		t.Errorf("slave read %q", got)
+	}
+
+	// Nobody reads the slave, the write blocks once its queue is full
+	master.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := master.Write(make([]byte, 1<<20)); !errors.Is(err, os.ErrDeadlineExceeded) || n == 1<<20 {
+		t.Errorf("Write() = %d, %v, want deadline exceeded", n, err)
+	}
+}
+
+func TestPTYCloseUnblocksRead(t *testing.T) {
+	for _, c := range []Config{{}, {ReadTimeout: time.Minute}, {InterByteTimeout: time.Minute}} {
+		_, slave := newPTYPair(t)
+		c.Name = slave.f.Name()
+		c.Baud = 115200
+		p, err := OpenPort(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error, 1)
+		go func() {
+			_, err := p.Read(make([]byte, 64))
+			errc <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		p.Close()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, os.ErrClosed) {
+				t.Errorf("%+v: Read() = %v, want closed", c, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%+v: Close did not unblock Read", c)
+		}
+	}
+}
+
+func TestPTYLineErrors(t *testing.T) {
+	_, slave := newPTYPair(t)
+	p, err := OpenPort(&Config{Name: slave.f.Name(), Baud: 115200, Parity: ParityNone, LineErrors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// Framing errors are only marked with INPCK, whatever the parity
+	var st syscall.Termios
+	if err := p.ioctl(syscall.TCGETS, uintptr(unsafe.Pointer(&st))); err != nil {
+		t.Fatal(err)
+	}
+	if st.Iflag&(syscall.PARMRK|syscall.INPCK) != syscall.PARMRK|syscall.INPCK {
+		t.Errorf("iflag %#o, want PARMRK and INPCK", st.Iflag)
+	}
+}
+
+func TestPTYExclusive(t *testing.T) {
+	_, slave := newPTYPair(t)
+	c := Config{Name: slave.f.Name(), Baud: 115200, Exclusive: true, LockDir: t.TempDir()}
+	p, err := OpenPort(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPort(&c); !errors.Is(err, ErrPortBusy) {
+		t.Errorf("second open error = %v, want ErrPortBusy", err)
+	}
+	// Without a lock file the open is rejected by TIOCEXCL or flock
+	c2 := c
+	c2.LockDir = ""
+	if _, err := OpenPort(&c2); !errors.Is(err, ErrPortBusy) {
+		t.Errorf("second open without lock file error = %v, want ErrPortBusy", err)
+	}
+
+	// Close releases the port
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err = OpenPort(&c)
+	if err != nil {
+		t.Fatalf("open after Close: %v", err)
+	}
+	p.Close()
+}
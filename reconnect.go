@@ -0,0 +1,114 @@
+package serial
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ReconnectPolicy controls how a SerialPort reopens its device after losing it,
+// e.g. when a USB adapter is unplugged. Attempts are spaced with an exponential
+// backoff starting at MinDelay and capped at MaxDelay.
+type ReconnectPolicy struct {
+	MinDelay    time.Duration // Delay before the first attempt, default is 500ms
+	MaxDelay    time.Duration // Upper bound of the delay, default is 30s
+	MaxAttempts int           // Attempts before giving up, 0 retries forever
+}
+
+// AutoReconnect makes the serial port reopen its device with the same
+// configuration when it is lost. A nil policy disables reconnection, which is
+// the default: the port is then closed when the device is lost.
+//
+// Transports attached with OpenWith are not reopened. Other ports are reopened
+// by name, so opening them through a usb: or by-id: selector (see ResolvePort)
+// finds the device again under a new device node.
+// EventDisconnect and EventReconnect are raised for every loss and recovery.
+func (sp *SerialPort) AutoReconnect(policy *ReconnectPolicy) {
+	p := reconnectPolicy(policy)
+	sp.mu.Lock()
+	sp.reconnect = p
+	sp.mu.Unlock()
+}
+
+// reconnectPolicy returns a copy of policy with the default delays set, or nil.
+func reconnectPolicy(policy *ReconnectPolicy) *ReconnectPolicy {
+	if policy == nil {
+		return nil
+	}
+	p := *policy
+	if p.MinDelay <= 0 {
+		p.MinDelay = time.Millisecond * 500
+	}
+	if p.MaxDelay < p.MinDelay {
+		p.MaxDelay = time.Second * 30
+		if p.MaxDelay < p.MinDelay {
+			p.MaxDelay = p.MinDelay
+		}
+	}
+	return &p
+}
+
+// lost handles the loss of port, called by the reader goroutine of the port
+// opened along with done. It returns the reopened port once reading can go
+// on, or nil.
+func (sp *SerialPort) lost(port io.ReadWriteCloser, done chan struct{}, err error) io.ReadWriteCloser {
+	sp.mu.Lock()
+	select {
+	case <-done:
+		// Closed by Close
+		sp.mu.Unlock()
+		return nil
+	default:
+	}
+	// Close only ends the reconnection from now on
+	sp.port = nil
+	baud, policy, reopen := sp.baud, sp.reconnect, sp.openPort
+	sp.mu.Unlock()
+
+	sp.log(slog.LevelWarn, "Serial port lost", "err", err)
+	port.Close()
+	sp.emit(Event{Type: EventDisconnect, Err: err})
+
+	if policy != nil && reopen != nil {
+		delay := policy.MinDelay
+		for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+			select {
+			case <-time.After(delay):
+			case <-done:
+				return nil
+			}
+			port, err := reopen()
+			if err == nil {
+				sp.mu.Lock()
+				select {
+				case <-done:
+					sp.mu.Unlock()
+					port.Close()
+					return nil
+				default:
+				}
+				sp.port = port
+				sp.mu.Unlock()
+				sp.log(slog.LevelInfo, "Serial port reconnected", "baud", baud)
+				sp.emit(Event{Type: EventReconnect})
+				sp.notifyMu.Lock()
+				sp.startModemWatch()
+				sp.notifyMu.Unlock()
+				return port
+			}
+			sp.log(slog.LevelWarn, "Reconnect attempt failed", "attempt", attempt, "err", err)
+			if delay *= 2; delay > policy.MaxDelay {
+				delay = policy.MaxDelay
+			}
+		}
+		sp.log(slog.LevelError, "Giving up reconnecting")
+	}
+	sp.mu.Lock()
+	select {
+	case <-done:
+	default:
+		sp.shutdown()
+	}
+	sp.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,160 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lostConn is a transport which fails Close once closed, as Port does.
+type lostConn struct {
+	net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *lostConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return os.ErrClosed
+	}
+	c.closed = true
+	return c.Conn.Close()
+}
+
+// fakeReopen returns a reopen function failing fails times before returning
+// the transports of next, and the times of its calls.
+func fakeReopen(fails int, next ...net.Conn) (reopen func() (io.ReadWriteCloser, error), calls func() []time.Time) {
+	var mu sync.Mutex
+	var times []time.Time
+	reopen = func() (io.ReadWriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if len(times) <= fails || len(next) == 0 {
+			return nil, errors.New("device unplugged")
+		}
+		c := next[0]
+		next = next[1:]
+		return &lostConn{Conn: c}, nil
+	}
+	calls = func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), times...)
+	}
+	return reopen, calls
+}
+
+// openLost attaches sp to a transport which is lost at once.
+func openLost(t *testing.T, sp *SerialPort, reopen func() (io.ReadWriteCloser, error)) {
+	t.Helper()
+	local, remote := net.Pipe()
+	if err := sp.attach(&lostConn{Conn: local}, Config{Name: "lost", ReadTimeout: time.Second}, nil, reopen); err != nil {
+		t.Fatal(err)
+	}
+	remote.Close()
+}
+
+func waitEvent(t *testing.T, events chan Event, want EventType) {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.Type != want {
+			t.Fatalf("event %v, want %v", e.Type, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event %v", want)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	sp := newTestSerialPort()
+	defer sp.Close()
+	events := make(chan Event, 16)
+	sp.Notify(events)
+	sp.AutoReconnect(&ReconnectPolicy{MinDelay: 10 * time.Millisecond, MaxDelay: 25 * time.Millisecond})
+	local, remote := net.Pipe()
+	defer remote.Close()
+	reopen, calls := fakeReopen(3, local)
+	openLost(t, sp, reopen)
+
+	waitEvent(t, events, EventDisconnect)
+	waitEvent(t, events, EventReconnect)
+	times := calls()
+	if len(times) != 4 {
+		t.Fatalf("%d attempts, want 4", len(times))
+	}
+	// The delay doubles from MinDelay up to MaxDelay
+	for i, want := range []time.Duration{20, 25, 25} {
+		if d := times[i+1].Sub(times[i]); d < want*time.Millisecond {
+			t.Errorf("delay before attempt %d is %v, want %v", i+2, d, want*time.Millisecond)
+		}
+	}
+
+	go remote.Write([]byte("back\r\n"))
+	if line, err := sp.ReadLine(); err != nil || line != "back" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+}
+
+func TestReconnectMaxAttempts(t *testing.T) {
+	sp := newTestSerialPort()
+	events := make(chan Event, 16)
+	sp.Notify(events)
+	sp.AutoReconnect(&ReconnectPolicy{MinDelay: time.Millisecond, MaxAttempts: 3})
+	reopen, calls := fakeReopen(0)
+	openLost(t, sp, reopen)
+
+	waitEvent(t, events, EventDisconnect)
+	timeout := time.After(2 * time.Second)
+	for {
+		sp.mu.Lock()
+		open := sp.portIsOpen
+		sp.mu.Unlock()
+		if !open {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("port still open after giving up")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if n := len(calls()); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e.Type)
+	default:
+	}
+}
+
+func TestReconnectClose(t *testing.T) {
+	sp := newTestSerialPort()
+	events := make(chan Event, 16)
+	sp.Notify(events)
+	sp.AutoReconnect(&ReconnectPolicy{MinDelay: 20 * time.Millisecond})
+	reopen, calls := fakeReopen(0)
+	openLost(t, sp, reopen)
+
+	waitEvent(t, events, EventDisconnect)
+	if _, err := sp.Write([]byte("AT\r\n")); err == nil {
+		t.Error("Write() succeeded while reconnecting")
+	}
+	// The lost port is not closed again, the backoff ends at once
+	if err := sp.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	n := len(calls())
+	time.Sleep(100 * time.Millisecond)
+	if m := len(calls()); m != n {
+		t.Errorf("%d attempts after Close", m-n)
+	}
+}
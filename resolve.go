@@ -0,0 +1,120 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrPortNotFound is returned when no serial port matches a selector.
+var ErrPortNotFound = errors.New("no serial port matches the selector")
+
+// ResolvePort returns the device node designated by name, which is either a
+// plain device name or one of the following selectors:
+//
+//	usb:<vid>:<pid>                  e.g. usb:0403:6001
+//	usb:<vid>:<pid>:serial=<serial>  e.g. usb:0403:6001:serial=A12345
+//	usb:<vid>:<pid>:if=<n>           e.g. usb:0403:6010:if=1
+//	by-id:<alias>                    alias under /dev/serial/by-id
+//
+// The if attribute selects a port of a multi-port adapter, such as the FT2232
+// or FT4232, by its hexadecimal USB interface number. Attributes may be
+// combined, e.g. usb:0403:6011:if=2:serial=FT4XYZ; serial comes last as the
+// serial number may contain colons.
+//
+// Plain names are returned unchanged. OpenPort and SerialPort.Open resolve the
+// selectors, so they can be used wherever a port name is expected.
+func ResolvePort(name string) (string, error) {
+	switch {
+	case strings.HasPrefix(name, "usb:"):
+		ports, err := ListPorts()
+		if err != nil {
+			return "", err
+		}
+		return resolveUSB(name, ports)
+	case strings.HasPrefix(name, "by-id:"):
+		return resolveByID(name, "/dev")
+	}
+	return name, nil
+}
+
+// resolveUSB returns the device of the single port of ports matching the usb selector.
+func resolveUSB(selector string, ports []PortInfo) (string, error) {
+	fields := strings.SplitN(strings.TrimPrefix(selector, "usb:"), ":", 3)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("invalid selector %q: want usb:<vid>:<pid>", selector)
+	}
+	vid, err := strconv.ParseUint(fields[0], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector %q: bad vendor ID", selector)
+	}
+	pid, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector %q: bad product ID", selector)
+	}
+	var serial string
+	var intf uint64
+	hasSerial, hasIntf := false, false
+	var attrs string
+	if len(fields) == 3 {
+		attrs = fields[2]
+	}
+	for attrs != "" {
+		var f string
+		if strings.HasPrefix(attrs, "serial=") {
+			// The serial number takes the rest of the selector
+			f, attrs = attrs, ""
+		} else {
+			f, attrs, _ = strings.Cut(attrs, ":")
+		}
+		kv := strings.SplitN(f, "=", 2)
+		switch {
+		case len(kv) == 2 && kv[0] == "serial":
+			serial = kv[1]
+			hasSerial = true
+		case len(kv) == 2 && kv[0] == "if":
+			if intf, err = strconv.ParseUint(kv[1], 16, 8); err != nil {
+				return "", fmt.Errorf("invalid selector %q: bad interface number", selector)
+			}
+			hasIntf = true
+		default:
+			return "", fmt.Errorf("invalid selector %q: unknown attribute %q", selector, f)
+		}
+	}
+
+	var matches []string
+	for _, p := range ports {
+		if !p.USB || p.VID != uint16(vid) || p.PID != uint16(pid) {
+			continue
+		}
+		if hasSerial && p.SerialNumber != serial {
+			continue
+		}
+		if hasIntf && p.Interface != uint8(intf) {
+			continue
+		}
+		matches = append(matches, p.Device)
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w %q", ErrPortNotFound, selector)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("selector %q is ambiguous, it matches %s", selector, strings.Join(matches, ", "))
+}
+
+// resolveByID follows the /dev/serial/by-id link of a by-id selector.
+func resolveByID(selector, devRoot string) (string, error) {
+	id := strings.TrimPrefix(selector, "by-id:")
+	if id == "" || strings.ContainsRune(id, '/') {
+		return "", fmt.Errorf("invalid selector %q", selector)
+	}
+	dev, err := filepath.EvalSymlinks(filepath.Join(devRoot, "serial", "by-id", id))
+	if err != nil {
+		return "", fmt.Errorf("%w %q", ErrPortNotFound, selector)
+	}
+	return dev, nil
+}
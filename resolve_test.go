@@ -0,0 +1,66 @@
+package serial
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveUSB(t *testing.T) {
+	ports := []PortInfo{
+		{Device: "/dev/ttyS4"},
+		{Device: "/dev/ttyUSB0", USB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "A12345"},
+		{Device: "/dev/ttyUSB1", USB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "B67890"},
+		{Device: "/dev/ttyACM0", USB: true, VID: 0x2341, PID: 0x0043},
+		{Device: "/dev/ttyUSB2", USB: true, VID: 0x0403, PID: 0x6010, SerialNumber: "FT:01", Interface: 0},
+		{Device: "/dev/ttyUSB3", USB: true, VID: 0x0403, PID: 0x6010, SerialNumber: "FT:01", Interface: 1},
+		{Device: "/dev/ttyUSB4", USB: true, VID: 0x0403, PID: 0x6010, SerialNumber: "FT:02", Interface: 1},
+	}
+	tests := []struct {
+		selector string
+		device   string
+		err      bool
+	}{
+		{"usb:2341:0043", "/dev/ttyACM0", false},
+		{"usb:0403:6001:serial=B67890", "/dev/ttyUSB1", false},
+		{"usb:0403:6001", "", true},             // ambiguous
+		{"usb:0403:6001:serial=none", "", true}, // not found
+		{"usb:0403", "", true},
+		{"usb:xyz:6001", "", true},
+		{"usb:0403:6001:iface=1", "", true},
+		{"usb:0403:6010:serial=FT:02", "/dev/ttyUSB4", false},
+		{"usb:0403:6010:if=1:serial=FT:01", "/dev/ttyUSB3", false},
+		{"usb:0403:6010:if=0", "/dev/ttyUSB2", false},
+		{"usb:0403:6010:if=1", "", true}, // ambiguous
+		{"usb:0403:6010:if=x", "", true},
+		{"usb:0403:6010:serial=FT:01:if=1", "", true}, // not found, serial comes last
+	}
+	for _, tt := range tests {
+		dev, err := resolveUSB(tt.selector, ports)
+		if dev != tt.device || (err != nil) != tt.err {
+			t.Errorf("resolveUSB(%q) = %q, %v", tt.selector, dev, err)
+		}
+	}
+}
+
+func TestResolveByID(t *testing.T) {
+	dev := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dev, "serial", "by-id"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dev, "ttyUSB3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../ttyUSB3", filepath.Join(dev, "serial", "by-id", "usb-FTDI-if00")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := resolveByID("by-id:usb-FTDI-if00", dev)
+	if want, _ := filepath.EvalSymlinks(filepath.Join(dev, "ttyUSB3")); err != nil || got != want {
+		t.Errorf("resolveByID() = %q, %v, want %q", got, err, want)
+	}
+	if _, err := resolveByID("by-id:missing", dev); !errors.Is(err, ErrPortNotFound) {
+		t.Errorf("resolveByID(missing) error = %v, want ErrPortNotFound", err)
+	}
+}
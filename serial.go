@@ -1,129 +1,350 @@
 package serial
 
 import (
-	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 )
 
 // End of line character (AKA EOL), newline character (ASCII 10, CR, '\n'). is used by default.
 const EOL_DEFAULT byte = '\n'
 
+// Line terminators used by default, a line ending with "\r\n" is stripped of both.
+var defaultTerms = []string{"\r\n", string(EOL_DEFAULT)}
+
+// Size of the chunks written by WriteContext between two checks of the context.
+const writeChunk = 64
+
+// Longest received line logged at once, unterminated data such as a binary
+// stream is logged in chunks of this size.
+const logLineMax = 1024
+
+// ErrTimeout is returned by WaitForRegexTimeout when the expression was not matched in time.
+var ErrTimeout = errors.New("timeout expired")
+
 /*******************************************************************************************
 *******************************   TYPE DEFINITIONS 	****************************************
 *******************************************************************************************/
 
+// SerialPort reads the data received by a port into a buffer, from which it
+// can be read line by line. Its methods are safe for concurrent use.
 type SerialPort struct {
-	port          io.ReadWriteCloser
-	name          string
-	baud          int
-	eol           uint8
-	rxChar        chan byte
-	closeReqChann chan bool
-	closeAckChann chan error
-	buff          *bytes.Buffer
-	logger        *log.Logger
-	portIsOpen    bool
-	Verbose       bool
-	waitline      chan struct{}
-	readTimeout   time.Duration
-	// openPort      func(port string, baud int) (io.ReadWriteCloser, error)
+	writeMu sync.Mutex // serializes the writes, which share the write deadline
+
+	mu          sync.Mutex // guards the fields below
+	logger      *slog.Logger
+	verbose     bool   // Log the traffic and the state of the port, see SetVerbose
+	base        Config // Configuration set by the options given to New
+	optErr      error  // Invalid option given to New
+	port        io.ReadWriteCloser
+	name        string
+	baud        int
+	terms       []string // Line terminators, never modified once set
+	buff        *ring
+	buffSize    int
+	buffPolicy  OverflowPolicy
+	portIsOpen  bool
+	readTimeout time.Duration
+	done        chan struct{} // closed when the port is closed
+	reconnect   *ReconnectPolicy
+	openPort    func() (io.ReadWriteCloser, error)
+
+	notifyMu   sync.Mutex // guards the fields below, acquired before mu
+	notify     []chan<- Event
+	modemWatch io.ReadWriteCloser // port whose modem lines are watched, if any
 }
 
 /*******************************************************************************************
 ********************************   BASIC FUNCTIONS  ****************************************
 *******************************************************************************************/
 
-func New() *SerialPort {
-	// Create new file
-	file, err := os.OpenFile(fmt.Sprintf("log_serial_%d.txt", time.Now().Unix()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalln("Failed to open log file", ":", err)
+// New returns a serial port configured by opts, which apply to every port
+// opened with Open. An invalid option makes Open fail.
+//
+// Unless WithLogger is given, the serial port logs to the standard output,
+// including the transmitted and received data at the debug level.
+func New(opts ...Option) *SerialPort {
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+	s := settings{
+		cfg:    Config{Baud: DefaultBaud, ReadTimeout: time.Second * 1},
+		terms:  defaultTerms,
+		logger: slog.New(handler),
 	}
-	multi := io.MultiWriter(file, os.Stdout)
-	return &SerialPort{
-		logger:  log.New(multi, "PREFIX: ", log.Ldate|log.Ltime),
-		eol:     EOL_DEFAULT,
-		buff:    bytes.NewBuffer(make([]uint8, 1024)),
-		Verbose: true,
+	sp := &SerialPort{verbose: true}
+	sp.optErr = s.apply(opts)
+	if sp.optErr == nil {
+		cfg := s.cfg
+		cfg.setDefaults()
+		sp.optErr = cfg.validate()
 	}
+	sp.mu.Lock()
+	sp.base = s.cfg
+	sp.configure(&s)
+	sp.mu.Unlock()
+	return sp
 }
 
-func (sp *SerialPort) Open(name string, baud int, timeout ...time.Duration) error {
-	// Check if port is open
-	if sp.portIsOpen {
+// NewWithLogger returns a serial port logging to logger. Every record has a
+// port attribute, records about data have dir ("tx" or "rx"), bytes, data and
+// hex attributes and are logged at the debug level. A nil logger disables
+// logging.
+func NewWithLogger(logger *slog.Logger) *SerialPort {
+	return New(WithLogger(logger))
+}
+
+// Open opens the serial port name configured by the options given to New,
+// overridden by opts. All the options are checked before opening the port.
+// Once the port is open, opts are kept for the next ports opened with Open;
+// they are dropped if it fails to open.
+//
+//	err := sp.Open("/dev/ttyUSB0", serial.WithBaud(115200), serial.WithFlowControl(serial.FlowHardware))
+func (sp *SerialPort) Open(name string, opts ...Option) error {
+	sp.mu.Lock()
+	err := sp.optErr
+	s := settings{
+		cfg:        sp.base,
+		terms:      sp.terms,
+		buffSize:   sp.buffSize,
+		buffPolicy: sp.buffPolicy,
+		logger:     sp.logger,
+		reconnect:  sp.reconnect,
+	}
+	open := sp.portIsOpen
+	sp.mu.Unlock()
+	if open {
 		return fmt.Errorf("\"%s\" is already open", name)
 	}
-	//var readTimeout time.Duration
-	sp.readTimeout = time.Second * 1
-	if len(timeout) > 0 {
-		sp.readTimeout = timeout[0]
+	if err == nil {
+		err = s.apply(opts)
+	}
+	if err == nil {
+		cfg := s.cfg
+		cfg.setDefaults()
+		err = cfg.validate()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to open port \"%s\" - %w", name, err)
+	}
+	cfg := s.cfg
+	cfg.Name = name
+	return sp.openConfig(&cfg, &s)
+}
+
+// configure applies the settings other than the port configuration. Must be
+// called with mu held.
+func (sp *SerialPort) configure(s *settings) {
+	sp.terms = s.terms
+	sp.buffSize, sp.buffPolicy = s.buffSize, s.buffPolicy
+	sp.logger = s.logger
+	sp.reconnect = reconnectPolicy(s.reconnect)
+}
+
+// OpenConfig opens the serial port described by c, allowing the data bits, parity,
+// stop bits and flow control to be selected. A zero ReadTimeout selects the one
+// second default used by Open.
+func (sp *SerialPort) OpenConfig(c *Config) error {
+	return sp.openConfig(c, nil)
+}
+
+// openConfig opens the serial port described by c. The settings s, if not
+// nil, replace those of the serial port once it is open.
+func (sp *SerialPort) openConfig(c *Config, s *settings) error {
+	sp.mu.Lock()
+	err, open := sp.optErr, sp.portIsOpen
+	sp.mu.Unlock()
+	// Check if port is open
+	if open {
+		return fmt.Errorf("\"%s\" is already open", c.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to open port \"%s\" - %w", c.Name, err)
+	}
+	cfg := *c
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = time.Second * 1
 	}
 	// Open serial port
-	comPort, err := openPort(name, baud, sp.readTimeout)
+	comPort, err := OpenPort(&cfg)
 	if err != nil {
-		return fmt.Errorf("Unable to open port \"%s\" - %s", name, err)
+		return fmt.Errorf("Unable to open port \"%s\" - %w", cfg.Name, err)
 	}
-	// Open port succesfull
-	sp.name = name
-	sp.baud = baud
+	err = sp.attach(comPort, cfg, s, func() (io.ReadWriteCloser, error) {
+		return OpenPort(&cfg)
+	})
+	if err != nil {
+		comPort.Close()
+	}
+	return err
+}
+
+// OpenWith attaches the serial port to an already open transport, e.g. a TCP
+// connection to a network serial server, one end of a pseudo terminal or an
+// in-memory pipe. The name is only used for logging. The transport is closed
+// by Close and is not reopened if lost. Reads wait for the read timeout set by
+// the options given to New.
+//
+// Operations specific to serial hardware (modem lines, breaks, flushing) are
+// available if the transport implements them, as Port does, otherwise they
+// return ErrNotSupported.
+func (sp *SerialPort) OpenWith(name string, rwc io.ReadWriteCloser) error {
+	sp.mu.Lock()
+	err, timeout := sp.optErr, sp.base.ReadTimeout
+	sp.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Unable to open port \"%s\" - %w", name, err)
+	}
+	return sp.attach(rwc, Config{Name: name, ReadTimeout: timeout}, nil, nil)
+}
+
+// attach starts serving the open port described by cfg, with the settings s
+// if not nil. The port is reopened with reopen when lost, if not nil.
+func (sp *SerialPort) attach(comPort io.ReadWriteCloser, cfg Config, s *settings, reopen func() (io.ReadWriteCloser, error)) error {
+	sp.mu.Lock()
+	// Check if port is open
+	if sp.portIsOpen {
+		sp.mu.Unlock()
+		return fmt.Errorf("\"%s\" is already open", cfg.Name)
+	}
+	if s != nil {
+		sp.base = s.cfg
+		sp.configure(s)
+	}
+	sp.readTimeout = cfg.ReadTimeout
+	sp.name = cfg.Name
+	sp.baud = cfg.Baud
 	sp.port = comPort
+	sp.openPort = reopen
 	sp.portIsOpen = true
-	sp.buff.Reset()
-	// Open channels
-	sp.rxChar = make(chan byte)
-	sp.waitline = make(chan struct{})
+	sp.buff = newRing(sp.buffSize, sp.buffPolicy)
+	sp.done = make(chan struct{})
+	buff, done := sp.buff, sp.done
+	sp.mu.Unlock()
+
 	// Enable threads
-	go sp.readSerialPort()
-	go sp.processSerialPort()
-	sp.logger.SetPrefix(fmt.Sprintf("[%s] ", sp.name))
-	sp.log("Serial port %s@%d open", sp.name, sp.baud)
+	go sp.readSerialPort(comPort, buff, done)
+	if cfg.Baud > 0 {
+		sp.log(slog.LevelInfo, "Serial port open", "baud", cfg.Baud)
+	} else {
+		sp.log(slog.LevelInfo, "Serial port open")
+	}
+	sp.notifyMu.Lock()
+	sp.startModemWatch()
+	sp.notifyMu.Unlock()
 	return nil
 }
 
 // This method close the current Serial Port.
+//
+// Close may be called concurrently with any other method: pending reads of
+// the serial buffer return an error.
 func (sp *SerialPort) Close() error {
-	if sp.portIsOpen {
-		sp.portIsOpen = false
-		close(sp.rxChar)
-		sp.log("Serial port %s closed", sp.name)
-		return sp.port.Close()
+	sp.mu.Lock()
+	if !sp.portIsOpen {
+		sp.mu.Unlock()
+		return nil
 	}
-	return nil
+	port := sp.port
+	sp.shutdown()
+	sp.mu.Unlock()
+	sp.log(slog.LevelInfo, "Serial port closed")
+	if port == nil {
+		// Lost and being reconnected
+		return nil
+	}
+	return port.Close()
 }
 
 // This method prints data trough the serial port.
 func (sp *SerialPort) Write(data []byte) (n int, err error) {
-	if sp.portIsOpen {
-		n, err = sp.port.Write(data)
+	port, err := sp.current()
+	if err != nil {
+		return 0, err
+	}
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	n, err = port.Write(data)
+	if err == nil {
+		sp.logData("tx", data)
+	}
+	return
+}
+
+// WriteContext writes data trough the serial port, giving up when ctx is done.
+// It returns the number of bytes written and ctx.Err() if ctx expired first.
+//
+// Data is written in small chunks so cancellation is noticed between two of
+// them. Transports supporting write deadlines, such as net.Conn or Port on
+// Linux, are also interrupted in the middle of a chunk.
+func (sp *SerialPort) WriteContext(ctx context.Context, data []byte) (n int, err error) {
+	port, err := sp.current()
+	if err != nil {
+		return 0, err
+	}
+	// The deadline is set, used and cleared by one write at a time
+	sp.writeMu.Lock()
+	defer sp.writeMu.Unlock()
+	if d, ok := port.(writeDeadliner); ok {
+		if t, ok := ctx.Deadline(); ok {
+			d.SetWriteDeadline(t)
+		}
+		expired := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			d.SetWriteDeadline(time.Unix(1, 0))
+			close(expired)
+		})
+		defer func() {
+			if !stop() {
+				<-expired
+			}
+			d.SetWriteDeadline(time.Time{})
+		}()
+	}
+	for n < len(data) {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		end := n + writeChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		var m int
+		m, err = port.Write(data[n:end])
+		n += m
 		if err != nil {
-			// Do nothing
-		} else {
-			sp.log("Tx >> %s", string(data))
+			if t, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(t) {
+				// The write deadline may expire just before ctx
+				<-ctx.Done()
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			break
 		}
-	} else {
-		err = fmt.Errorf("Serial port is not open")
 	}
-	return
+	if n > 0 {
+		sp.logData("tx", data[:n])
+	}
+	return n, err
 }
 
 // This method prints data trough the serial port.
 func (sp *SerialPort) Print(str string) error {
-	if sp.portIsOpen {
-		_, err := sp.port.Write([]byte(str))
-		if err != nil {
-			return err
-		} else {
-			sp.log("Tx >> %s", str)
-		}
-	} else {
-		return fmt.Errorf("Serial port is not open")
+	port, err := sp.current()
+	if err != nil {
+		return err
+	}
+	if _, err := port.Write([]byte(str)); err != nil {
+		return err
 	}
+	sp.logData("tx", []byte(str))
 	return nil
 }
 
@@ -142,20 +363,24 @@ func (sp *SerialPort) Printf(format string, args ...interface{}) error {
 	return sp.Print(str)
 }
 
-//This method send a binary file trough the serial port. If EnableLog is active then this method will log file related data.
+// This method send a binary file trough the serial port. If EnableLog is active then this method will log file related data.
 func (sp *SerialPort) SendFile(filepath string) error {
 	// Aux Vars
 	sentBytes := 0
 	q := 512
 	data := []byte{}
+	port, err := sp.current()
+	if err != nil {
+		return err
+	}
 	// Read file
 	file, err := ioutil.ReadFile(filepath)
 	if err != nil {
-		sp.log("DBG >> %s", "Invalid filepath")
+		sp.log(slog.LevelError, "Unable to read file", "file", filepath, "err", err)
 		return err
 	} else {
 		fileSize := len(file)
-		sp.log("INF >> %s", "File size is %d bytes", fileSize)
+		sp.log(slog.LevelInfo, "Sending file", "file", filepath, "bytes", fileSize)
 
 		for sentBytes <= fileSize {
 			//Try sending slices of less or equal than 512 bytes at time
@@ -165,9 +390,9 @@ func (sp *SerialPort) SendFile(filepath string) error {
 				data = file[sentBytes:]
 			}
 			// Write binaries
-			_, err := sp.port.Write(data)
+			_, err := port.Write(data)
 			if err != nil {
-				sp.log("DBG >> %s", "Error while sending the file")
+				sp.log(slog.LevelError, "Unable to send file", "file", filepath, "err", err)
 				return err
 			} else {
 				sentBytes += q
@@ -181,180 +406,395 @@ func (sp *SerialPort) SendFile(filepath string) error {
 
 // Read the first byte of the serial buffer.
 func (sp *SerialPort) Read() (byte, error) {
-	if sp.portIsOpen {
-		return sp.buff.ReadByte()
-	} else {
+	sp.mu.Lock()
+	open, buff := sp.portIsOpen, sp.buff
+	sp.mu.Unlock()
+	if !open {
 		return 0x00, fmt.Errorf("Serial port is not open")
 	}
-	return 0x00, nil
+	c, ok := buff.readByte()
+	if !ok {
+		return 0x00, io.EOF
+	}
+	return c, nil
 }
 
 // Read first available line from serial port buffer.
 //
-// Line is delimited by the EOL character, newline character (ASCII 10, LF, '\n') is used by default.
+// Line is delimited by one of the terminators set by SetTerminators, "\r\n" or '\n' by default.
 //
-// The text returned from ReadLine does not include the line end ("\r\n" or '\n').
+// The text returned from ReadLine does not include the terminator.
+// If no line is received within the read timeout, the unread data is returned.
 func (sp *SerialPort) ReadLine() (string, error) {
-	if sp.portIsOpen {
+	sp.mu.Lock()
+	timeout := sp.readTimeout
+	sp.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	line, err := sp.ReadLineContext(ctx)
+	if err == context.DeadlineExceeded {
+		sp.mu.Lock()
+		buff := sp.buff
+		sp.mu.Unlock()
+		return string(buff.peek()), nil
+	}
+	return line, err
+}
+
+// ReadLineContext reads the first available line from the serial buffer like
+// ReadLine, waiting for it until ctx is done. It returns ctx.Err() if no line
+// was received.
+func (sp *SerialPort) ReadLineContext(ctx context.Context) (string, error) {
+	line, _, err := sp.ReadLineTerm(ctx)
+	return line, err
+}
+
+// ReadLineTerm reads the first available line from the serial buffer like
+// ReadLineContext, also returning the terminator which ended the line.
+func (sp *SerialPort) ReadLineTerm(ctx context.Context) (line, term string, err error) {
+	for {
+		sp.mu.Lock()
+		open, buff, terms, done := sp.portIsOpen, sp.buff, sp.terms, sp.done
+		sp.mu.Unlock()
+		if !open {
+			return "", "", fmt.Errorf("Serial port is not open")
+		}
+		ready := buff.wait()
+		if line, term, ok := buff.readLine(terms); ok {
+			return string(line), term, nil
+		}
+
 		select {
-		case <-sp.waitline:
-			line, err := sp.buff.ReadString(sp.eol)
-			if err != nil {
-				fmt.Printf("ReadLine err!=%v\n", err)
-				return "", err
-			} else {
-				return removeEOL(line), nil
-			}
-		case <-time.After(sp.readTimeout):
-			return sp.buff.String(), nil
+		case <-ready:
+		case <-done:
+		case <-ctx.Done():
+			return "", "", ctx.Err()
 		}
-	} else {
-		return "", fmt.Errorf("Serial port is not open")
 	}
-	return "", nil
 }
 
 // Wait for a defined regular expression for a defined amount of time.
 func (sp *SerialPort) WaitForRegexTimeout(exp string, timeout time.Duration) (string, error) {
+	regExpPatttern := regexp.MustCompile(exp)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	data, err := sp.WaitForRegexContext(ctx, regExpPatttern)
+	if err == context.DeadlineExceeded {
+		return "", ErrTimeout
+	}
+	return data, err
+}
 
-	if sp.portIsOpen {
-		//Decode received data
-		timeExpired := false
-
-		regExpPatttern := regexp.MustCompile(exp)
-
-		//Timeout structure
-		c1 := make(chan string, 1)
-		go func() {
-			sp.log("INF >> Waiting for RegExp: \"%s\"", exp)
-			result := []string{}
-			for !timeExpired {
-				//fmt.Printf("INF >> sp.Readline:\n")
-				line, err := sp.ReadLine()
-				//fmt.Printf("INF >> sp.Readline: \"%s\"\n", line)
-				if err != nil {
-					// Do nothing
-				} else {
-
-					result = regExpPatttern.FindAllString(line, -1)
-					if len(result) > 0 {
-						c1 <- result[0]
-						break
-					}
-					sp.log("INF >> not match: \"%s\"", line)
-				}
-			}
-		}()
-		select {
-		case data := <-c1:
-			sp.log("INF >> The RegExp: \"%s\"", exp)
-			sp.log("INF >> Has been matched: \"%s\"", data)
+// WaitForRegexContext reads lines until one matches re and returns the matched
+// text. It returns ctx.Err() if ctx is done before a line matches.
+func (sp *SerialPort) WaitForRegexContext(ctx context.Context, re *regexp.Regexp) (string, error) {
+	if _, err := sp.current(); err != nil {
+		return "", err
+	}
+	sp.log(slog.LevelDebug, "Waiting for RegExp", "regexp", re.String())
+	for {
+		line, err := sp.ReadLineContext(ctx)
+		if err != nil {
+			sp.log(slog.LevelDebug, "Unable to match RegExp", "regexp", re.String(), "err", err)
+			return "", err
+		}
+		if loc := re.FindStringIndex(line); loc != nil {
+			data := line[loc[0]:loc[1]]
+			sp.log(slog.LevelDebug, "RegExp matched", "regexp", re.String(), "match", data)
 			return data, nil
-		case <-time.After(timeout):
-			timeExpired = true
-			sp.log("INF >> Unable to match RegExp: \"%s\"", exp)
-			return "", fmt.Errorf("Timeout expired")
 		}
-	} else {
-		return "", fmt.Errorf("Serial port is not open")
+		sp.log(slog.LevelDebug, "RegExp not matched", "regexp", re.String(), "line", line)
 	}
-	return "", nil
 }
 
 // Available return the total number of available unread bytes on the serial buffer.
 func (sp *SerialPort) Available() int {
-	return sp.buff.Len()
+	sp.mu.Lock()
+	buff := sp.buff
+	sp.mu.Unlock()
+	if buff == nil {
+		return 0
+	}
+	return buff.len()
+}
+
+// ResetBuffer discards the data received but not read yet, both in the serial
+// buffer and in the input queue of the port.
+func (sp *SerialPort) ResetBuffer() error {
+	port, err := sp.current()
+	if err != nil {
+		return err
+	}
+	if f, ok := port.(flusher); ok {
+		err = f.FlushInput()
+	}
+	sp.mu.Lock()
+	buff := sp.buff
+	sp.mu.Unlock()
+	buff.reset()
+	return err
+}
+
+// FlushInput discards data received by the port but not yet moved to the serial buffer.
+func (sp *SerialPort) FlushInput() error {
+	f, err := sp.flusher()
+	if err != nil {
+		return err
+	}
+	return f.FlushInput()
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (sp *SerialPort) FlushOutput() error {
+	f, err := sp.flusher()
+	if err != nil {
+		return err
+	}
+	return f.FlushOutput()
+}
+
+// Drain waits until all data written to the port has been transmitted, e.g.
+// before switching the direction of a RS-485 transceiver.
+func (sp *SerialPort) Drain() error {
+	f, err := sp.flusher()
+	if err != nil {
+		return err
+	}
+	return f.Drain()
+}
+
+// SetVerbose enables or disables logging, enabled by default. It may be
+// called at any time, including while the port is in use.
+func (sp *SerialPort) SetVerbose(on bool) {
+	sp.mu.Lock()
+	sp.verbose = on
+	sp.mu.Unlock()
 }
 
 // Change end of line character (AKA EOL), newline character (ASCII 10, LF, '\n') is used by default.
+// It is the same as SetTerminators with the single terminator c.
 func (sp *SerialPort) EOL(c byte) {
-	sp.eol = c
+	sp.SetTerminators(string([]byte{c}))
+}
+
+// SetTerminators sets the terminators ending the lines read by ReadLine, such
+// as "\r\n", "\r" or a "> " prompt. A line ends at the earliest terminator,
+// the longest one if several terminators match there, e.g. "\r\n" rather
+// than "\r". A terminator may be matched before a longer one is complete.
+func (sp *SerialPort) SetTerminators(terms ...string) error {
+	if err := checkTerms(terms); err != nil {
+		return err
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.terms = append([]string(nil), terms...)
+	return nil
 }
 
 /*******************************************************************************************
 ******************************   PRIVATE FUNCTIONS  ****************************************
 *******************************************************************************************/
 
-func (sp *SerialPort) readSerialPort() {
-	rxBuff := make([]byte, 256)
-	for sp.portIsOpen {
-		n, _ := sp.port.Read(rxBuff)
+// readSerialPort moves the data received by port to the serial buffer until
+// done is closed.
+func (sp *SerialPort) readSerialPort(port io.ReadWriteCloser, buff *ring, done chan struct{}) {
+	rxBuff := make([]byte, 4096)
+	var screenBuff []byte // received data not printed yet
+	for {
+		n, err := port.Read(rxBuff)
+		if _, ok := port.(*Port); err == io.EOF && !ok {
+			// End of the stream of a transport attached with OpenWith
+			err = io.ErrUnexpectedEOF
+		}
+		switch err {
+		case nil, io.EOF:
+			// EOF - Read timeout
+		case ErrBreak:
+			sp.log(slog.LevelInfo, "Break received", "dir", "rx")
+			sp.emit(Event{Type: EventBreak})
+		case ErrFraming:
+			sp.log(slog.LevelWarn, "Framing or parity error received", "dir", "rx")
+			sp.emit(Event{Type: EventFraming})
+		default:
+			if port = sp.lost(port, done, err); port == nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if n == 0 {
+			continue
+		}
 		// Write data to serial buffer
-		sp.buff.Write(rxBuff[:n])
+		if !buff.write(rxBuff[:n], done) {
+			return
+		}
+		if !sp.logging() {
+			continue
+		}
 
-		for _, b := range rxBuff[:n] {
-			if sp.portIsOpen {
-				sp.rxChar <- b
+		// Print received lines
+		sp.mu.Lock()
+		terms := sp.terms
+		sp.mu.Unlock()
+		screenBuff = append(screenBuff, rxBuff[:n]...)
+		start := 0
+		for {
+			i, term := findTerm(screenBuff[start:], nil, terms)
+			if i < 0 {
+				break
 			}
+			sp.logData("rx", screenBuff[start:start+i+len(term)])
+			start += i + len(term)
+		}
+		screenBuff = append(screenBuff[:0], screenBuff[start:]...)
+		for len(screenBuff) >= logLineMax {
+			sp.logData("rx", screenBuff[:logLineMax])
+			screenBuff = append(screenBuff[:0], screenBuff[logLineMax:]...)
 		}
+	}
+}
 
+// current returns the open port.
+func (sp *SerialPort) current() (io.ReadWriteCloser, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.portIsOpen {
+		return nil, fmt.Errorf("Serial port is not open")
+	}
+	if sp.port == nil {
+		return nil, fmt.Errorf("Serial port is lost, reconnecting")
 	}
+	return sp.port, nil
 }
 
-func (sp *SerialPort) processSerialPort() {
-	screenBuff := make([]byte, 0)
-	var lastRxByte byte
-	for {
-		if sp.portIsOpen {
-			lastRxByte = <-sp.rxChar
-			// Print received lines
-			switch lastRxByte {
-			case sp.eol:
-				// EOL - Print received data
-				sp.log("Rx << %s", string(append(screenBuff, lastRxByte)))
-				sp.waitline <- struct{}{}
-				screenBuff = make([]byte, 0) //Clean buffer
-				break
-			default:
-				screenBuff = append(screenBuff, lastRxByte)
-			}
-		} else {
-			break
-		}
+// shutdown marks the port as closed and wakes up the pending readers. Must be
+// called with mu held.
+func (sp *SerialPort) shutdown() {
+	sp.portIsOpen = false
+	close(sp.done)
+}
+
+// writeDeadliner is implemented by transports whose writes can be interrupted.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// flusher is implemented by ports able to flush and drain their queues.
+type flusher interface {
+	FlushInput() error
+	FlushOutput() error
+	Drain() error
+}
+
+func (sp *SerialPort) flusher() (flusher, error) {
+	port, err := sp.current()
+	if err != nil {
+		return nil, err
 	}
+	f, ok := port.(flusher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return f, nil
 }
 
-func (sp *SerialPort) log(format string, a ...interface{}) {
-	if sp.Verbose {
-		sp.logger.Printf(format, a...)
+// logTarget returns the logger and the name of the port, or a nil logger if
+// logging is disabled.
+func (sp *SerialPort) logTarget() (*slog.Logger, string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if !sp.verbose {
+		return nil, ""
 	}
+	return sp.logger, sp.name
 }
 
-func removeEOL(line string) string {
-	var data []byte
-	// Remove CR byte "\r"
-	for _, b := range []byte(line) {
-		switch b {
-		case '\r':
-			// Do nothing
-		case '\n':
-			// Do nothing
-		default:
-			data = append(data, b)
+func (sp *SerialPort) logging() bool {
+	logger, _ := sp.logTarget()
+	return logger != nil
+}
+
+// log emits a record with the name of the port.
+func (sp *SerialPort) log(level slog.Level, msg string, args ...any) {
+	logger, name := sp.logTarget()
+	if logger == nil {
+		return
+	}
+	logger.Log(context.Background(), level, msg, append([]any{"port", name}, args...)...)
+}
+
+// logData emits a record about data transmitted ("tx") or received ("rx").
+func (sp *SerialPort) logData(dir string, data []byte) {
+	if !sp.logging() {
+		return
+	}
+	sp.log(slog.LevelDebug, "Data "+dir, "dir", dir, "bytes", len(data), "data", string(data), "hex", hex.EncodeToString(data))
+}
+
+// checkTerms checks a set of line terminators.
+func checkTerms(terms []string) error {
+	if len(terms) == 0 {
+		return fmt.Errorf("no line terminator")
+	}
+	for _, t := range terms {
+		if t == "" {
+			return fmt.Errorf("empty line terminator")
 		}
 	}
-	return string(data)
-}
-
-// Converts the timeout values for Linux / POSIX systems
-func posixTimeoutValues(readTimeout time.Duration) (vmin uint8, vtime uint8) {
-	const MAXUINT8 = 1<<8 - 1 // 255
-	// set blocking / non-blocking read
-	var minBytesToRead uint8 = 1
-	var readTimeoutInDeci int64
-	if readTimeout > 0 {
-		// EOF on zero read
-		minBytesToRead = 0
-		// convert timeout to deciseconds as expected by VTIME
-		readTimeoutInDeci = (readTimeout.Nanoseconds() / 1e6 / 100)
-		// capping the timeout
-		if readTimeoutInDeci < 1 {
-			// min possible timeout 1 Deciseconds (0.1s)
-			readTimeoutInDeci = 1
-		} else if readTimeoutInDeci > MAXUINT8 {
-			// max possible timeout is 255 deciseconds (25.5s)
-			readTimeoutInDeci = MAXUINT8
+	return nil
+}
+
+// readTimeouts reads into b from a tty in non-blocking mode, applying the
+// total and inter-byte read timeouts in userspace. wait reports whether data
+// can be read within d, or waits forever when d is negative. Reading nothing
+// before the timeout expires returns io.EOF, as a VMIN/VTIME read would.
+func readTimeouts(b []byte, total, gap time.Duration, wait func(d time.Duration) (bool, error), read func([]byte) (int, error)) (int, error) {
+	var deadline time.Time
+	if total > 0 {
+		deadline = time.Now().Add(total)
+	}
+	n := 0
+	for n < len(b) {
+		d := time.Duration(-1)
+		if n > 0 {
+			if gap <= 0 {
+				break
+			}
+			d = gap
+		}
+		if total > 0 {
+			left := time.Until(deadline)
+			if left <= 0 {
+				break
+			}
+			if d < 0 || left < d {
+				d = left
+			}
+		}
+		ready, err := wait(d)
+		if err != nil {
+			return n, err
+		}
+		if !ready {
+			break
+		}
+		m, err := read(b[n:])
+		n += m
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
 	}
-	return minBytesToRead, uint8(readTimeoutInDeci)
+	return n, nil
 }
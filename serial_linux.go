@@ -1,15 +1,20 @@
-// +build linux,!cgo
+//go:build linux
+// +build linux
 
 package serial
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 )
 
-func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
+func openPort(c *Config) (p *Port, err error) {
 	var bauds = map[int]uint32{
 		50:      syscall.B50,
 		75:      syscall.B75,
@@ -43,60 +48,312 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		4000000: syscall.B4000000,
 	}
 
-	rate := bauds[baud]
-
-	if rate == 0 {
-		return
+	rate, standard := bauds[c.Baud]
+	if !standard {
+		// Any rate will do, it is replaced through termios2 below
+		rate = syscall.B38400
 	}
 
-	f, err := os.OpenFile(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	framing, err := termiosFraming(c)
 	if err != nil {
 		return nil, err
 	}
 
+	var lock string
+	if c.LockDir != "" {
+		if lock, err = uucpLock(c.LockDir, c.Name); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				os.Remove(lock)
+			}
+		}()
+	}
+
+	f, err := os.OpenFile(c.Name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	if err != nil {
+		return nil, busyError(err)
+	}
+
 	defer func() {
 		if err != nil && f != nil {
 			f.Close()
 		}
 	}()
 
-	fd := f.Fd()
-	vmin, vtime := posixTimeoutValues(readTimeout)
+	// The fd stays in non-blocking mode, registered with the runtime poller,
+	// which lets deadlines and Close interrupt reads and writes. Calling
+	// f.Fd() would switch it back to blocking mode.
+	if p, err = newPort(f); err != nil {
+		return nil, err
+	}
+	p.excl, p.lock = c.Exclusive, lock
+	p.timeout, p.gap = c.ReadTimeout, c.InterByteTimeout
+
+	// The kernel takes the speed from Cflag, the length of Cc and the speed
+	// fields of syscall.Termios vary between architectures
 	t := syscall.Termios{
-		Iflag:  syscall.IGNPAR,
-		Cflag:  syscall.CS8 | syscall.CREAD | syscall.CLOCAL | rate,
-		Cc:     [32]uint8{syscall.VMIN: vmin, syscall.VTIME: vtime},
-		Ispeed: rate,
-		Ospeed: rate,
+		Iflag: syscall.IGNPAR,
+		Cflag: framing | syscall.CREAD | syscall.CLOCAL | rate,
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if c.LineErrors {
+		// Mark breaks and bad characters in the stream instead of dropping
+		// them. Without INPCK framing errors are not reported either.
+		t.Iflag = syscall.PARMRK | syscall.INPCK
+	}
+	switch c.Flow {
+	case FlowHardware:
+		t.Cflag |= crtscts
+	case FlowSoftware:
+		t.Iflag |= syscall.IXON | syscall.IXOFF
+		t.Cc[syscall.VSTART] = c.XonChar
+		t.Cc[syscall.VSTOP] = c.XoffChar
+	}
+
+	err = p.control(func(fd uintptr) error {
+		if c.Exclusive {
+			if err := flockExclusive(fd); err != nil {
+				return err
+			}
+			if err := ioctl(fd, syscall.TIOCEXCL, 0); err != nil {
+				return err
+			}
+		}
+		if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+			return err
+		}
+		if !standard {
+			return setCustomBaud(fd, c.Baud)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if _, _, errno := syscall.Syscall6(
-		syscall.SYS_IOCTL,
-		uintptr(fd),
-		uintptr(syscall.TCSETS),
-		uintptr(unsafe.Pointer(&t)),
-		0,
-		0,
-		0,
-	); errno != 0 {
-		return nil, errno
+	if c.LineErrors {
+		p.marks = &markReader{read: p.read}
 	}
+	return p, nil
+}
 
-	if err = syscall.SetNonblock(int(fd), false); err != nil {
-		return
+// setCustomBaud programs a non standard baud rate using BOTHER. The rate
+// read back from the driver must be within 2% of the requested one.
+func setCustomBaud(fd uintptr, baud int) error {
+	var t termios2
+	if err := ioctl(fd, tcgets2, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	t.Cflag &^= cbaud
+	t.Cflag |= bother
+	t.Ispeed = uint32(baud)
+	t.Ospeed = uint32(baud)
+	if err := ioctl(fd, tcsets2, uintptr(unsafe.Pointer(&t))); err != nil {
+		return fmt.Errorf("%w %d: %v", ErrBadBaud, baud, err)
 	}
+	if err := ioctl(fd, tcgets2, uintptr(unsafe.Pointer(&t))); err != nil {
+		return err
+	}
+	diff := int(t.Ospeed) - baud
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff*50 > baud {
+		return fmt.Errorf("%w %d: driver selected %d", ErrBadBaud, baud, t.Ospeed)
+	}
+	return nil
+}
 
-	return &Port{f: f}, nil
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// termiosFraming returns the c_cflag bits selecting the data bits, parity
+// and stop bits described by c.
+func termiosFraming(c *Config) (cflag uint32, err error) {
+	switch c.Size {
+	case 5:
+		cflag |= syscall.CS5
+	case 6:
+		cflag |= syscall.CS6
+	case 7:
+		cflag |= syscall.CS7
+	case 8:
+		cflag |= syscall.CS8
+	default:
+		return 0, ErrBadSize
+	}
+	switch c.Parity {
+	case ParityNone:
+	case ParityOdd:
+		cflag |= syscall.PARENB | syscall.PARODD
+	case ParityEven:
+		cflag |= syscall.PARENB
+	case ParityMark:
+		cflag |= syscall.PARENB | syscall.PARODD | cmspar
+	case ParitySpace:
+		cflag |= syscall.PARENB | cmspar
+	default:
+		return 0, ErrBadParity
+	}
+	switch c.StopBits {
+	case Stop1:
+	case Stop2:
+		cflag |= syscall.CSTOPB
+	default:
+		// 1.5 stop bits can not be expressed through termios
+		return 0, ErrBadStopBits
+	}
+	return cflag, nil
 }
 
 type Port struct {
 	// We intentionly do not use an "embedded" struct so that we
 	// don't export File
-	f *os.File
+	f     *os.File
+	rc    syscall.RawConn // access to the fd of f without blocking mode
+	marks *markReader     // set when line errors are reported
+	excl  bool            // set when opened in exclusive mode
+	lock  string          // UUCP lock file, if any
+
+	closed    chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	timeout time.Duration // total read timeout, if any
+	gap     time.Duration // inter-byte read timeout, if any
+
+	mu       sync.Mutex
+	deadline time.Time // read deadline set by SetReadDeadline
+	limit    time.Time // end of the wait of poll, if any
+}
+
+// newPort wraps a tty opened in non-blocking mode.
+func newPort(f *os.File) (*Port, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return &Port{f: f, rc: rc, closed: make(chan struct{})}, nil
 }
 
 func (p *Port) Read(b []byte) (n int, err error) {
-	return p.f.Read(b)
+	if p.marks != nil {
+		return p.marks.Read(b)
+	}
+	return p.read(b)
+}
+
+// read reads from the tty, waiting for data as long as the read timeouts allow.
+func (p *Port) read(b []byte) (int, error) {
+	if p.timeout <= 0 && p.gap <= 0 {
+		return p.readTTY(b)
+	}
+	return readTimeouts(b, p.timeout, p.gap, p.poll, p.readTTY)
+}
+
+// readTTY reads from the tty once. A zero length read returns io.EOF, unless
+// the tty was hung up, e.g. because the USB adapter was unplugged.
+func (p *Port) readTTY(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	if n == 0 && err == io.EOF {
+		var t syscall.Termios
+		if err := p.ioctl(syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+			return 0, err
+		}
+	}
+	return n, err
+}
+
+// pollFd mirrors the kernel struct pollfd.
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollIn = 0x1 // POLLIN
+
+// poll reports whether data can be read from the tty within d, waiting
+// forever if d is negative. A hung up tty is reported as readable, as well as
+// an expired read deadline so that the read reports it.
+func (p *Port) poll(d time.Duration) (bool, error) {
+	if d >= 0 {
+		p.setLimit(time.Now().Add(d))
+		defer p.setLimit(time.Time{})
+	}
+	err := p.rc.Read(func(fd uintptr) bool {
+		fds := []pollFd{{fd: int32(fd), events: pollIn}}
+		var ts syscall.Timespec
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+		return n > 0 || (errno != 0 && errno != syscall.EINTR)
+	})
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		p.mu.Lock()
+		expired := !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+		p.mu.Unlock()
+		return expired, nil
+	}
+	// Other errors, e.g. when the port is closed, are reported by the read
+	return true, nil
+}
+
+// setLimit bounds the read deadline of the file by the end of the wait of poll.
+func (p *Port) setLimit(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = t
+	p.applyDeadline()
+}
+
+// applyDeadline sets the read deadline of the file, the earliest of the
+// deadline and the limit. p.mu must be held.
+func (p *Port) applyDeadline() error {
+	t := p.deadline
+	if !p.limit.IsZero() && (t.IsZero() || p.limit.Before(t)) {
+		t = p.limit
+	}
+	return p.f.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads, a zero
+// value disabling it. Reads waiting past the deadline fail with an error
+// wrapping os.ErrDeadlineExceeded, unlike the read timeouts of the Config
+// returning io.EOF.
+func (p *Port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return p.applyDeadline()
+}
+
+// SetWriteDeadline sets the deadline for pending and future writes, a zero
+// value disabling it. Writes blocked past the deadline, e.g. by flow control,
+// fail with an error wrapping os.ErrDeadlineExceeded.
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	return p.f.SetWriteDeadline(t)
+}
+
+// control runs fn with the fd of the port, which cannot be released by
+// a concurrent Close meanwhile.
+func (p *Port) control(fn func(fd uintptr) error) error {
+	var err error
+	if cerr := p.rc.Control(func(fd uintptr) { err = fn(fd) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (p *Port) ioctl(req, arg uintptr) error {
+	return p.control(func(fd uintptr) error {
+		return ioctl(fd, req, arg)
+	})
 }
 
 func (p *Port) Write(b []byte) (n int, err error) {
@@ -106,16 +363,138 @@ func (p *Port) Write(b []byte) (n int, err error) {
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	const TCFLSH = 0x540B
-	_, _, err := syscall.Syscall(
-		syscall.SYS_IOCTL,
-		uintptr(p.f.Fd()),
-		uintptr(TCFLSH),
-		uintptr(syscall.TCIOFLUSH),
-	)
-	return err
+	return p.flush(syscall.TCIOFLUSH)
+}
+
+// FlushInput discards data received but not read.
+func (p *Port) FlushInput() error {
+	return p.flush(syscall.TCIFLUSH)
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (p *Port) FlushOutput() error {
+	return p.flush(syscall.TCOFLUSH)
+}
+
+// Drain waits until all data written to the port has been transmitted.
+func (p *Port) Drain() error {
+	// TCSBRK with a non zero argument is tcdrain()
+	return p.ioctl(tcsbrk, 1)
+}
+
+func (p *Port) flush(queue uintptr) error {
+	if p.marks != nil && queue != syscall.TCOFLUSH {
+		p.marks.discard()
+	}
+	return p.ioctl(tcflsh, queue)
+}
+
+// SetDTR drives the Data Terminal Ready output line.
+func (p *Port) SetDTR(on bool) error {
+	return p.setModemBits(syscall.TIOCM_DTR, on)
+}
+
+// SetRTS drives the Request To Send output line.
+func (p *Port) SetRTS(on bool) error {
+	return p.setModemBits(syscall.TIOCM_RTS, on)
+}
+
+// ModemStatus returns the current state of the modem status input lines.
+func (p *Port) ModemStatus() (ModemStatus, error) {
+	var bits uint32
+	if err := p.ioctl(syscall.TIOCMGET, uintptr(unsafe.Pointer(&bits))); err != nil {
+		return ModemStatus{}, err
+	}
+	return ModemStatus{
+		CTS: bits&syscall.TIOCM_CTS != 0,
+		DSR: bits&syscall.TIOCM_DSR != 0,
+		DCD: bits&syscall.TIOCM_CAR != 0,
+		RI:  bits&syscall.TIOCM_RNG != 0,
+	}, nil
+}
+
+// SendBreak holds the transmit line in the spacing state for the duration d.
+func (p *Port) SendBreak(d time.Duration) error {
+	if err := p.ioctl(syscall.TIOCSBRK, 0); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return p.ioctl(syscall.TIOCCBRK, 0)
+}
+
+// WaitModemChange blocks until one of the CTS, DSR, DCD or RI lines changes
+// state. It fails once the port is closed.
+//
+// Nothing but a line change or a hang up interrupts TIOCMIWAIT, so it waits on
+// a duplicate of the fd, which Close does not wait for. The lines of drivers
+// without TIOCMIWAIT are polled every 10 milliseconds.
+func (p *Port) WaitModemChange() error {
+	var dup uintptr
+	err := p.control(func(fd uintptr) error {
+		r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD_CLOEXEC, 0)
+		if errno != 0 {
+			return errno
+		}
+		dup = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	res := make(chan error, 1)
+	go func() {
+		res <- ioctl(dup, syscall.TIOCMIWAIT, syscall.TIOCM_CTS|syscall.TIOCM_DSR|syscall.TIOCM_CAR|syscall.TIOCM_RNG)
+		syscall.Close(int(dup))
+	}()
+	select {
+	case err = <-res:
+	case <-p.closed:
+		return os.ErrClosed
+	}
+	if err != syscall.ENOTTY && err != syscall.EINVAL {
+		return err
+	}
+
+	prev, err := p.ModemStatus()
+	if err != nil {
+		return err
+	}
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-p.closed:
+			return os.ErrClosed
+		}
+		st, err := p.ModemStatus()
+		if err != nil || st != prev {
+			return err
+		}
+	}
+}
+
+func (p *Port) setModemBits(bits uint32, on bool) error {
+	req := uintptr(syscall.TIOCMBIC)
+	if on {
+		req = syscall.TIOCMBIS
+	}
+	return p.ioctl(req, uintptr(unsafe.Pointer(&bits)))
 }
 
 func (p *Port) Close() (err error) {
-	return p.f.Close()
+	p.closeOnce.Do(func() { close(p.closed) })
+	if p.excl {
+		// The fd duplicated by a pending WaitModemChange keeps the tty open
+		// until the wait returns, the lock is not left to the last close
+		p.control(func(fd uintptr) error {
+			syscall.Flock(int(fd), syscall.LOCK_UN)
+			return ioctl(fd, syscall.TIOCNXCL, 0)
+		})
+	}
+	err = p.f.Close()
+	if p.lock != "" {
+		os.Remove(p.lock)
+	}
+	return err
 }
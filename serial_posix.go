@@ -1,9 +1,18 @@
-// +build !windows,cgo
+//go:build !windows && !linux && cgo
+// +build !windows,!linux,cgo
 
 package serial
 
 // #include <termios.h>
 // #include <unistd.h>
+// #include <poll.h>
+// #include <sys/ioctl.h>
+//
+// static int wait_readable(int fd, int ms) { struct pollfd p = { fd, POLLIN, 0 }; return poll(&p, 1, ms); }
+// static int get_modem_bits(int fd, int *bits) { return ioctl(fd, TIOCMGET, bits); }
+// static int set_modem_bits(int fd, int bits, int on) { return ioctl(fd, on ? TIOCMBIS : TIOCMBIC, &bits); }
+// static int set_break(int fd, int on) { return ioctl(fd, on ? TIOCSBRK : TIOCCBRK); }
+// static int set_exclusive(int fd, int on) { return ioctl(fd, on ? TIOCEXCL : TIOCNXCL); }
 import "C"
 
 // TODO: Maybe change to using syscall package + ioctl instead of cgo
@@ -11,16 +20,29 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"syscall"
 	"time"
 	//"unsafe"
 )
 
-func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
-	f, err := os.OpenFile(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+func openPort(c *Config) (p *Port, err error) {
+	var lock string
+	if c.LockDir != "" {
+		if lock, err = uucpLock(c.LockDir, c.Name); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				os.Remove(lock)
+			}
+		}()
+	}
+
+	f, err := os.OpenFile(c.Name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
 	if err != nil {
-		return
+		return nil, busyError(err)
 	}
 
 	fd := C.int(f.Fd())
@@ -29,6 +51,17 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		return nil, errors.New("File is not a tty")
 	}
 
+	if c.Exclusive {
+		if err = flockExclusive(f.Fd()); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if r, e := C.set_exclusive(fd, 1); r != 0 {
+			f.Close()
+			return nil, e
+		}
+	}
+
 	var st C.struct_termios
 	_, err = C.tcgetattr(fd, &st)
 	if err != nil {
@@ -36,7 +69,9 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		return nil, err
 	}
 	var speed C.speed_t
-	switch baud {
+	switch c.Baud {
+	case 230400:
+		speed = C.B230400
 	case 115200:
 		speed = C.B115200
 	case 57600:
@@ -51,9 +86,29 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		speed = C.B4800
 	case 2400:
 		speed = C.B2400
+	case 1800:
+		speed = C.B1800
+	case 1200:
+		speed = C.B1200
+	case 600:
+		speed = C.B600
+	case 300:
+		speed = C.B300
+	case 200:
+		speed = C.B200
+	case 150:
+		speed = C.B150
+	case 134:
+		speed = C.B134
+	case 110:
+		speed = C.B110
+	case 75:
+		speed = C.B75
+	case 50:
+		speed = C.B50
 	default:
 		f.Close()
-		return nil, fmt.Errorf("Unknown baud rate %v", baud)
+		return nil, fmt.Errorf("%w %v", ErrBadBaud, c.Baud)
 	}
 
 	_, err = C.cfsetispeed(&st, speed)
@@ -69,10 +124,60 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 
 	// Turn off break interrupts, CR->NL, Parity checks, strip, and IXON
 	st.c_iflag &= ^C.tcflag_t(C.BRKINT | C.ICRNL | C.INPCK | C.ISTRIP | C.IXOFF | C.IXON | C.PARMRK)
+	st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
 
-	// Select local mode, turn off parity, set to 8 bits
-	st.c_cflag &= ^C.tcflag_t(C.CSIZE | C.PARENB)
-	st.c_cflag |= (C.CLOCAL | C.CREAD | C.CS8)
+	if c.LineErrors {
+		// Mark breaks and bad characters in the stream instead of dropping
+		// them. Without INPCK framing errors are not reported either.
+		st.c_iflag &= ^C.tcflag_t(C.IGNBRK | C.IGNPAR)
+		st.c_iflag |= C.PARMRK | C.INPCK
+	}
+
+	// Select flow control
+	switch c.Flow {
+	case FlowHardware:
+		st.c_cflag |= C.CRTSCTS
+	case FlowSoftware:
+		st.c_iflag |= C.IXON | C.IXOFF
+		st.c_cc[C.VSTART] = C.cc_t(c.XonChar)
+		st.c_cc[C.VSTOP] = C.cc_t(c.XoffChar)
+	}
+
+	// Select local mode and the requested data bits, parity and stop bits
+	st.c_cflag &= ^C.tcflag_t(C.CSIZE | C.PARENB | C.PARODD | C.CSTOPB)
+	st.c_cflag |= (C.CLOCAL | C.CREAD)
+	switch c.Size {
+	case 5:
+		st.c_cflag |= C.CS5
+	case 6:
+		st.c_cflag |= C.CS6
+	case 7:
+		st.c_cflag |= C.CS7
+	case 8:
+		st.c_cflag |= C.CS8
+	default:
+		f.Close()
+		return nil, ErrBadSize
+	}
+	switch c.Parity {
+	case ParityNone:
+	case ParityOdd:
+		st.c_cflag |= C.PARENB | C.PARODD
+	case ParityEven:
+		st.c_cflag |= C.PARENB
+	default:
+		// Mark and space parity need CMSPAR which is Linux only
+		f.Close()
+		return nil, ErrBadParity
+	}
+	switch c.StopBits {
+	case Stop1:
+	case Stop2:
+		st.c_cflag |= C.CSTOPB
+	default:
+		f.Close()
+		return nil, ErrBadStopBits
+	}
 
 	// Select raw mode
 	st.c_lflag &= ^C.tcflag_t(C.ICANON | C.ECHO | C.ECHOE | C.ISIG)
@@ -81,11 +186,15 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 	// set blocking / non-blocking read
 	/*
 	*	http://man7.org/linux/man-pages/man3/termios.3.html
-	* - Supports blocking read and read with timeout operations
+	* - Timeouts are handled by poll in read, which needs reads
+	*   returning at once
 	 */
-	vmin, vtime := posixTimeoutValues(readTimeout)
-	st.c_cc[C.VMIN] = C.cc_t(vmin)
-	st.c_cc[C.VTIME] = C.cc_t(vtime)
+	var vmin C.cc_t = 1
+	if c.ReadTimeout > 0 || c.InterByteTimeout > 0 {
+		vmin = 0
+	}
+	st.c_cc[C.VMIN] = vmin
+	st.c_cc[C.VTIME] = 0
 
 	_, err = C.tcsetattr(fd, C.TCSANOW, &st)
 	if err != nil {
@@ -116,30 +225,211 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 				}
 	*/
 
-	return &Port{f: f}, nil
+	p = &Port{f: f, excl: c.Exclusive, lock: lock, timeout: c.ReadTimeout, gap: c.InterByteTimeout}
+	if c.LineErrors {
+		p.marks = &markReader{read: p.read}
+	}
+	return p, nil
 }
 
 type Port struct {
 	// We intentionly do not use an "embedded" struct so that we
 	// don't export File
-	f *os.File
+	f     *os.File
+	marks *markReader // set when line errors are reported
+	excl  bool        // set when opened in exclusive mode
+	lock  string      // UUCP lock file, if any
+
+	timeout time.Duration // total read timeout, if any
+	gap     time.Duration // inter-byte read timeout, if any
 }
 
 func (p *Port) Read(b []byte) (n int, err error) {
-	return p.f.Read(b)
+	if p.marks != nil {
+		return p.marks.Read(b)
+	}
+	return p.read(b)
+}
+
+// read reads from the tty, waiting for data as long as the read timeouts allow.
+func (p *Port) read(b []byte) (int, error) {
+	if p.timeout <= 0 && p.gap <= 0 {
+		return p.readTTY(b)
+	}
+	return readTimeouts(b, p.timeout, p.gap, p.poll, p.readTTY)
+}
+
+// readTTY reads from the tty once. A zero length read is a timeout and returns
+// io.EOF, unless the tty was hung up, e.g. because the USB adapter was unplugged.
+func (p *Port) readTTY(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	if n == 0 && err == io.EOF {
+		var st C.struct_termios
+		if r, err := C.tcgetattr(C.int(p.f.Fd()), &st); r != 0 {
+			return 0, err
+		}
+	}
+	return n, err
+}
+
+// poll reports whether data can be read from the tty within d, waiting
+// forever if d is negative. A hung up tty is reported as readable.
+func (p *Port) poll(d time.Duration) (ready bool, err error) {
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(d)
+	// Control keeps the fd from being released by a concurrent Close
+	cerr := rc.Control(func(fd uintptr) {
+		for {
+			ms := -1
+			if d >= 0 {
+				// round up, poll would spin on sub-millisecond waits
+				ms = int((time.Until(deadline) + time.Millisecond - 1) / time.Millisecond)
+				if ms < 0 {
+					ms = 0
+				} else if ms > 1<<31-1 {
+					ms = 1<<31 - 1
+				}
+			}
+			r, e := C.wait_readable(C.int(fd), C.int(ms))
+			if r < 0 && e == syscall.EINTR {
+				continue
+			}
+			if r < 0 {
+				err = e
+			}
+			ready = r > 0
+			return
+		}
+	})
+	if cerr != nil {
+		return false, cerr
+	}
+	return ready, err
 }
 
 func (p *Port) Write(b []byte) (n int, err error) {
 	return p.f.Write(b)
 }
 
+// SetReadDeadline is only supported on Linux, it returns os.ErrNoDeadline.
+func (p *Port) SetReadDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+// SetWriteDeadline is only supported on Linux, it returns os.ErrNoDeadline.
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	_, err := C.tcflush(C.int(p.f.Fd()), C.TCIOFLUSH)
-	return err
+	return p.flush(C.TCIOFLUSH)
+}
+
+// FlushInput discards data received but not read.
+func (p *Port) FlushInput() error {
+	return p.flush(C.TCIFLUSH)
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (p *Port) FlushOutput() error {
+	return p.flush(C.TCOFLUSH)
+}
+
+// Drain waits until all data written to the port has been transmitted.
+func (p *Port) Drain() error {
+	if r, err := C.tcdrain(C.int(p.f.Fd())); r != 0 {
+		return err
+	}
+	return nil
+}
+
+func (p *Port) flush(queue C.int) error {
+	if p.marks != nil && queue != C.TCOFLUSH {
+		p.marks.discard()
+	}
+	if r, err := C.tcflush(C.int(p.f.Fd()), queue); r != 0 {
+		return err
+	}
+	return nil
+}
+
+// SetDTR drives the Data Terminal Ready output line.
+func (p *Port) SetDTR(on bool) error {
+	return p.setModemBits(C.TIOCM_DTR, on)
+}
+
+// SetRTS drives the Request To Send output line.
+func (p *Port) SetRTS(on bool) error {
+	return p.setModemBits(C.TIOCM_RTS, on)
+}
+
+// ModemStatus returns the current state of the modem status input lines.
+func (p *Port) ModemStatus() (ModemStatus, error) {
+	var bits C.int
+	if r, err := C.get_modem_bits(C.int(p.f.Fd()), &bits); r != 0 {
+		return ModemStatus{}, err
+	}
+	return ModemStatus{
+		CTS: bits&C.TIOCM_CTS != 0,
+		DSR: bits&C.TIOCM_DSR != 0,
+		DCD: bits&C.TIOCM_CAR != 0,
+		RI:  bits&C.TIOCM_RNG != 0,
+	}, nil
+}
+
+// SendBreak holds the transmit line in the spacing state for the duration d.
+func (p *Port) SendBreak(d time.Duration) error {
+	if r, err := C.set_break(C.int(p.f.Fd()), 1); r != 0 {
+		return err
+	}
+	time.Sleep(d)
+	if r, err := C.set_break(C.int(p.f.Fd()), 0); r != 0 {
+		return err
+	}
+	return nil
+}
+
+// WaitModemChange blocks until one of the CTS, DSR, DCD or RI lines changes
+// state. It fails once the port is closed.
+//
+// The lines are polled every 10 milliseconds, as TIOCMIWAIT is not portable.
+func (p *Port) WaitModemChange() error {
+	prev, err := p.ModemStatus()
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(time.Millisecond * 10)
+		st, err := p.ModemStatus()
+		if err != nil || st != prev {
+			return err
+		}
+	}
+}
+
+func (p *Port) setModemBits(bits C.int, on bool) error {
+	var set C.int
+	if on {
+		set = 1
+	}
+	if r, err := C.set_modem_bits(C.int(p.f.Fd()), bits, set); r != 0 {
+		return err
+	}
+	return nil
 }
 
 func (p *Port) Close() (err error) {
-	return p.f.Close()
+	if p.excl {
+		C.set_exclusive(C.int(p.f.Fd()), 0)
+	}
+	err = p.f.Close()
+	if p.lock != "" {
+		os.Remove(p.lock)
+	}
+	return err
 }
@@ -0,0 +1,643 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argandas/serial/mock"
+)
+
+// newTestSerialPort returns a SerialPort which does not log.
+func newTestSerialPort() *SerialPort {
+	return NewWithLogger(nil)
+}
+
+func TestOpenWith(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	sp := newTestSerialPort()
+	events := make(chan Event, 4)
+	sp.Notify(events)
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	go remote.Write([]byte("+CSQ: 21,0\r\n"))
+	if line, err := sp.ReadLine(); err != nil || line != "+CSQ: 21,0" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+
+	go sp.Println("AT+CSQ")
+	if got := readFull(t, remote, 8); got != "AT+CSQ\r\n" {
+		t.Errorf("remote read %q", got)
+	}
+
+	if err := sp.SetDTR(true); err != ErrNotSupported {
+		t.Errorf("SetDTR() = %v, want ErrNotSupported", err)
+	}
+
+	// The end of the stream is a disconnection
+	remote.Close()
+	select {
+	case e := <-events:
+		if e.Type != EventDisconnect || e.Port != "pipe" {
+			t.Errorf("got %s event for %q", e.Type, e.Port)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no disconnect event")
+	}
+}
+
+func TestMockDevice(t *testing.T) {
+	dev := mock.New()
+	dev.Expect("AT\r\n").After(20 * time.Millisecond).Reply("OK\r\n")
+	dev.Expect("AT+CSQ\r\n").ReplyChunks("\r\n+CSQ: 21,0\r\n\r\nOK\r\n", 3, time.Millisecond)
+	dev.Expect("AT+CFUN=1,1\r\n").Reply("OK\r\n").Disconnect()
+
+	sp := newTestSerialPort()
+	events := make(chan Event, 4)
+	sp.Notify(events)
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	sp.Println("AT")
+	if line, err := sp.ReadLine(); err != nil || line != "OK" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+	sp.Println("AT+CSQ")
+	if got, err := sp.WaitForRegexTimeout(`\+CSQ: \d+`, time.Second); err != nil || got != "+CSQ: 21" {
+		t.Errorf("WaitForRegexTimeout() = %q, %v", got, err)
+	}
+	if got, err := sp.WaitForRegexTimeout(`^OK$`, time.Second); err != nil || got != "OK" {
+		t.Errorf("WaitForRegexTimeout() = %q, %v", got, err)
+	}
+
+	sp.Println("AT+CFUN=1,1")
+	select {
+	case e := <-events:
+		if e.Type != EventDisconnect {
+			t.Errorf("got %s event", e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no disconnect event")
+	}
+	if err := dev.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	dev := mock.New()
+	dev.Expect("AT+COPS?\r\n").After(50 * time.Millisecond).Reply("+COPS: 0\r\n")
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sp.ReadLineContext(ctx); err != context.Canceled {
+		t.Errorf("ReadLineContext() = %v, want context.Canceled", err)
+	}
+	if _, err := sp.WaitForRegexTimeout(`OK`, 10*time.Millisecond); err != ErrTimeout {
+		t.Errorf("WaitForRegexTimeout() = %v, want ErrTimeout", err)
+	}
+
+	sp.Println("AT+COPS?")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := sp.WaitForRegexContext(ctx, regexp.MustCompile(`\+COPS`)); err != context.DeadlineExceeded {
+		t.Errorf("WaitForRegexContext() = %v, want context.DeadlineExceeded", err)
+	}
+	// The late reply is still there for the next reader
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if got, err := sp.WaitForRegexContext(ctx, regexp.MustCompile(`\+COPS: (\d)`)); err != nil || got != "+COPS: 0" {
+		t.Errorf("WaitForRegexContext() = %q, %v", got, err)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left, had %d", n, goroutines)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	// Nobody reads the remote end: the write is stuck until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sp.WriteContext(ctx, []byte("AT\r\n")); err != context.DeadlineExceeded {
+		t.Errorf("WriteContext() = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The deadline is cleared for the following writes
+	data := bytes.Repeat([]byte("0123456789"), 20)
+	go func() {
+		if n, err := sp.WriteContext(context.Background(), data); err != nil || n != len(data) {
+			t.Errorf("WriteContext() = %d, %v", n, err)
+		}
+	}()
+	if got := readFull(t, remote, len(data)); got != string(data) {
+		t.Errorf("remote read %q", got)
+	}
+}
+
+func TestWriteContextConcurrent(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	// The deadline of a short write does not cut the other one
+	data := bytes.Repeat([]byte("0123456789"), 20)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if n, err := sp.WriteContext(context.Background(), data); err != nil || n != len(data) {
+			t.Errorf("WriteContext() = %d, %v", n, err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := sp.WriteContext(ctx, []byte("AT\r\n")); err != context.DeadlineExceeded {
+			t.Errorf("WriteContext() = %v, want context.DeadlineExceeded", err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if got := readFull(t, remote, len(data)); got != string(data) {
+		t.Errorf("remote read %q", got)
+	}
+	wg.Wait()
+}
+
+// TestConcurrentUse is mostly useful with -race.
+func TestConcurrentUse(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	go func() {
+		for {
+			if _, err := remote.Write([]byte("+CREG: 1\r\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	worker := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && f() == nil {
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		worker(func() error {
+			_, err := sp.ReadLineContext(ctx)
+			return err
+		})
+		worker(func() error { return sp.Println("AT+CREG?") })
+	}
+	worker(func() error {
+		_, err := sp.Read()
+		if err == io.EOF {
+			// Empty buffer
+			err = nil
+		}
+		sp.Available()
+		return err
+	})
+	worker(func() error {
+		_, err := sp.WaitForRegexContext(ctx, regexp.MustCompile(`CREG`))
+		return err
+	})
+	worker(func() error { return sp.ResetBuffer() })
+	worker(func() error {
+		events := make(chan Event, 1)
+		sp.Notify(events)
+		sp.Stop(events)
+		return nil
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	var closers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			sp.Close()
+		}()
+	}
+	closers.Wait()
+	wg.Wait()
+
+	if _, err := sp.ReadLineContext(context.Background()); err == nil {
+		t.Error("ReadLineContext() succeeded after Close")
+	}
+	if err := sp.Println("AT"); err == nil {
+		t.Error("Println() succeeded after Close")
+	}
+}
+
+func TestCloseUnblocksReaders(t *testing.T) {
+	dev := mock.New()
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		_, err := sp.ReadLineContext(context.Background())
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sp.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("ReadLineContext() succeeded after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock ReadLineContext")
+	}
+
+	// The reader of the previous port does not interfere with the next one
+	dev = mock.New()
+	dev.Expect("").Reply("RDY\r\n")
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	if line, err := sp.ReadLine(); err != nil || line != "RDY" {
+		t.Errorf("ReadLine() = %q, %v", line, err)
+	}
+}
+
+// modemPipe is a transport whose modem status lines are set by the test.
+type modemPipe struct {
+	net.Conn
+	mu    sync.Mutex
+	st    ModemStatus
+	polls int
+}
+
+func newModemPipe(t *testing.T) *modemPipe {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	return &modemPipe{Conn: local}
+}
+
+func (m *modemPipe) ModemStatus() (ModemStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.polls++
+	return m.st, nil
+}
+
+func (m *modemPipe) set(st ModemStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.st = st
+}
+
+func (m *modemPipe) pollCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.polls
+}
+
+func TestModemEventsAfterReopen(t *testing.T) {
+	sp := newTestSerialPort()
+	events := make(chan Event, 16)
+	sp.Notify(events)
+	waitModem := func(want ModemStatus) {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case e := <-events:
+				if e.Type == EventModem && e.Modem == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no modem event for %+v", want)
+			}
+		}
+	}
+
+	first := newModemPipe(t)
+	if err := sp.OpenWith("first", first); err != nil {
+		t.Fatal(err)
+	}
+	first.set(ModemStatus{CTS: true})
+	waitModem(ModemStatus{CTS: true})
+
+	// Close stops watching the port at once, the next port is watched
+	sp.Close()
+	second := newModemPipe(t)
+	if err := sp.OpenWith("second", second); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	second.set(ModemStatus{DSR: true})
+	waitModem(ModemStatus{DSR: true})
+
+	polls := first.pollCount()
+	time.Sleep(5 * modemPollInterval)
+	if n := first.pollCount(); n != polls {
+		t.Errorf("closed port polled %d more times", n-polls)
+	}
+}
+
+// modemWaitPipe is a modemPipe whose changes are waited for.
+type modemWaitPipe struct {
+	*modemPipe
+	changed   chan struct{}
+	closed    chan struct{}
+	returned  chan struct{} // WaitModemChange returned after Close
+	closeOnce sync.Once
+}
+
+func newModemWaitPipe(t *testing.T) *modemWaitPipe {
+	return &modemWaitPipe{
+		modemPipe: newModemPipe(t),
+		changed:   make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		returned:  make(chan struct{}),
+	}
+}
+
+func (m *modemWaitPipe) change(st ModemStatus) {
+	m.set(st)
+	m.changed <- struct{}{}
+}
+
+func (m *modemWaitPipe) WaitModemChange() error {
+	select {
+	case <-m.changed:
+		return nil
+	case <-m.closed:
+		close(m.returned)
+		return os.ErrClosed
+	}
+}
+
+func (m *modemWaitPipe) Close() error {
+	m.closeOnce.Do(func() { close(m.closed) })
+	return m.modemPipe.Close()
+}
+
+func TestModemEventsWait(t *testing.T) {
+	sp := newTestSerialPort()
+	events := make(chan Event, 16)
+	sp.Notify(events)
+	dev := newModemWaitPipe(t)
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	dev.change(ModemStatus{DCD: true, RI: true})
+	select {
+	case e := <-events:
+		want := ModemStatus{DCD: true, RI: true}
+		if e.Type != EventModem || e.Modem != want || e.Changed != want {
+			t.Errorf("event %+v, want lines and changes %+v", e, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no modem event")
+	}
+	polls := dev.pollCount()
+	time.Sleep(5 * modemPollInterval)
+	if n := dev.pollCount(); n != polls {
+		t.Errorf("waited lines polled %d more times", n-polls)
+	}
+
+	// Close ends the wait
+	sp.Close()
+	select {
+	case <-dev.returned:
+	case <-time.After(time.Second):
+		t.Fatal("WaitModemChange still waiting after Close")
+	}
+}
+
+func TestTerminators(t *testing.T) {
+	dev := mock.New()
+	dev.Expect("").Reply("AT\rX\r\nOK\n")
+	dev.Expect("AT+CMGS=1\r").Reply("\r\n> ")
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range [][2]string{{"AT\rX", "\r\n"}, {"OK", "\n"}} {
+		if line, term, err := sp.ReadLineTerm(ctx); err != nil || line != want[0] || term != want[1] {
+			t.Errorf("ReadLineTerm() = %q, %q, %v, want %q", line, term, err, want)
+		}
+	}
+
+	if err := sp.SetTerminators(); err == nil {
+		t.Error("SetTerminators() accepted no terminator")
+	}
+	sp.SetTerminators("\r\n", "> ")
+	sp.Print("AT+CMGS=1\r")
+	for _, want := range [][2]string{{"", "\r\n"}, {"", "> "}} {
+		if line, term, err := sp.ReadLineTerm(ctx); err != nil || line != want[0] || term != want[1] {
+			t.Errorf("ReadLineTerm() = %q, %q, %v, want %q", line, term, err, want)
+		}
+	}
+}
+
+func TestLogging(t *testing.T) {
+	dev := mock.New()
+	dev.Expect("AT\r\n").Reply("OK\r\n")
+	var out lockedBuffer
+	sp := NewWithLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	sp.Println("AT")
+	sp.ReadLine()
+
+	want := []map[string]any{
+		{"msg": "Serial port open", "port": "modem"},
+		{"port": "modem", "dir": "tx", "bytes": 4.0, "data": "AT\r\n", "hex": "41540d0a"},
+		{"port": "modem", "dir": "rx", "bytes": 4.0, "data": "OK\r\n", "hex": "4f4b0d0a"},
+	}
+	// Received data is logged by the reader goroutine
+	var records []map[string]any
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		records = records[:0]
+		for _, line := range bytes.Split(out.Bytes(), []byte("\n")) {
+			var r map[string]any
+			if json.Unmarshal(line, &r) == nil {
+				records = append(records, r)
+			}
+		}
+		if hasRecord(records, want[len(want)-1]) {
+			break
+		}
+	}
+	for _, w := range want {
+		if !hasRecord(records, w) {
+			t.Errorf("no record %v in:\n%s", w, out.Bytes())
+		}
+	}
+}
+
+func TestSetVerbose(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	var out lockedBuffer
+	sp := NewWithLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	// Logging may be toggled while the port is in use
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sp.SetVerbose(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		sp.Println("AT")
+	}
+	<-done
+
+	sp.SetVerbose(false)
+	sp.Println("quiet")
+	sp.SetVerbose(true)
+	sp.Println("loud")
+	if bytes.Contains(out.Bytes(), []byte("quiet")) || !bytes.Contains(out.Bytes(), []byte("loud")) {
+		t.Errorf("unexpected records:\n%s", out.Bytes())
+	}
+}
+
+func TestLoggingUnterminated(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	var out lockedBuffer
+	sp := NewWithLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err := sp.OpenWith("pipe", local); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+
+	// A stream without terminator is logged in bounded chunks
+	data := bytes.Repeat([]byte{0x55}, 3*logLineMax+10)
+	if _, err := remote.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"port": "pipe", "dir": "rx", "bytes": float64(logLineMax)}
+	var records []map[string]any
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		records = records[:0]
+		for _, line := range bytes.Split(out.Bytes(), []byte("\n")) {
+			var r map[string]any
+			if json.Unmarshal(line, &r) == nil {
+				records = append(records, r)
+			}
+		}
+		n := 0
+		for _, r := range records {
+			if hasRecord([]map[string]any{r}, want) {
+				n++
+			}
+		}
+		if n == 3 {
+			return
+		}
+	}
+	t.Errorf("no 3 records %v in:\n%s", want, out.Bytes())
+}
+
+func hasRecord(records []map[string]any, want map[string]any) bool {
+next:
+	for _, r := range records {
+		for k, v := range want {
+			if r[k] != v {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func readFull(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 package serial
@@ -18,6 +19,10 @@ type Port struct {
 	wl sync.Mutex
 	ro *syscall.Overlapped
 	wo *syscall.Overlapped
+	mo *syscall.Overlapped
+	me uint32 // event mask written by WaitCommEvent
+
+	lineErrors bool
 }
 
 type structDCB struct {
@@ -37,7 +42,8 @@ type structTimeouts struct {
 	WriteTotalTimeoutConstant   uint32
 }
 
-func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err error) {
+func openPort(c *Config) (p *Port, err error) {
+	name := c.Name
 	if len(name) > 0 && name[0] != '\\' {
 		name = "\\\\.\\" + name
 	}
@@ -49,6 +55,10 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		syscall.OPEN_EXISTING,
 		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
 		0)
+	if err == syscall.ERROR_ACCESS_DENIED {
+		// Ports are opened without sharing, the port is used by another process
+		return nil, fmt.Errorf("%w: %v", ErrPortBusy, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -59,16 +69,16 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 		}
 	}()
 
-	if err = setCommState(h, baud); err != nil {
+	if err = setCommState(h, c); err != nil {
 		return
 	}
 	if err = setupComm(h, 64, 64); err != nil {
 		return
 	}
-	if err = setCommTimeouts(h, readTimeout); err != nil {
+	if err = setCommTimeouts(h, c.ReadTimeout, c.InterByteTimeout); err != nil {
 		return
 	}
-	if err = setCommMask(h); err != nil {
+	if err = setCommMask(h, evModem); err != nil {
 		return
 	}
 
@@ -80,16 +90,24 @@ func openPort(name string, baud int, readTimeout time.Duration) (p *Port, err er
 	if err != nil {
 		return
 	}
+	mo, err := newOverlapped()
+	if err != nil {
+		return
+	}
 	port := new(Port)
 	port.f = f
 	port.fd = h
 	port.ro = ro
 	port.wo = wo
+	port.mo = mo
+	port.lineErrors = c.LineErrors
 
 	return port, nil
 }
 
 func (p *Port) Close() error {
+	// Clearing the mask completes a pending WaitCommEvent
+	setCommMask(p.fd, 0)
 	return p.f.Close()
 }
 
@@ -108,6 +126,16 @@ func (p *Port) Write(buf []byte) (int, error) {
 	return getOverlappedResult(p.fd, p.wo)
 }
 
+// SetReadDeadline is only supported on Linux, it returns os.ErrNoDeadline.
+func (p *Port) SetReadDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+// SetWriteDeadline is only supported on Linux, it returns os.ErrNoDeadline.
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
 func (p *Port) Read(buf []byte) (int, error) {
 	if p == nil || p.f == nil {
 		return 0, fmt.Errorf("Invalid port on read %v %v", p, p.f)
@@ -124,13 +152,113 @@ func (p *Port) Read(buf []byte) (int, error) {
 	if err != nil && err != syscall.ERROR_IO_PENDING {
 		return int(done), err
 	}
-	return getOverlappedResult(p.fd, p.ro)
+	n, err := getOverlappedResult(p.fd, p.ro)
+	if err == nil && p.lineErrors {
+		err = clearCommError(p.fd)
+	}
+	return n, err
 }
 
 // Discards data written to the port but not transmitted,
 // or data received but not read
 func (p *Port) Flush() error {
-	return purgeComm(p.fd)
+	return purgeComm(p.fd, purgeRx|purgeTx)
+}
+
+// FlushInput discards data received but not read.
+func (p *Port) FlushInput() error {
+	return purgeComm(p.fd, purgeRx)
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (p *Port) FlushOutput() error {
+	return purgeComm(p.fd, purgeTx)
+}
+
+// Drain waits until all data written to the port has been transmitted.
+func (p *Port) Drain() error {
+	r, _, err := syscall.Syscall(nFlushFileBuffers, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// SetDTR drives the Data Terminal Ready output line.
+func (p *Port) SetDTR(on bool) error {
+	const SETDTR = 5
+	const CLRDTR = 6
+	if on {
+		return escapeCommFunction(p.fd, SETDTR)
+	}
+	return escapeCommFunction(p.fd, CLRDTR)
+}
+
+// SetRTS drives the Request To Send output line.
+func (p *Port) SetRTS(on bool) error {
+	const SETRTS = 3
+	const CLRRTS = 4
+	if on {
+		return escapeCommFunction(p.fd, SETRTS)
+	}
+	return escapeCommFunction(p.fd, CLRRTS)
+}
+
+// ModemStatus returns the current state of the modem status input lines.
+func (p *Port) ModemStatus() (ModemStatus, error) {
+	const MS_CTS_ON = 0x0010
+	const MS_DSR_ON = 0x0020
+	const MS_RING_ON = 0x0040
+	const MS_RLSD_ON = 0x0080
+	var bits uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&bits)), 0)
+	if r == 0 {
+		return ModemStatus{}, err
+	}
+	return ModemStatus{
+		CTS: bits&MS_CTS_ON != 0,
+		DSR: bits&MS_DSR_ON != 0,
+		DCD: bits&MS_RLSD_ON != 0,
+		RI:  bits&MS_RING_ON != 0,
+	}, nil
+}
+
+// SendBreak holds the transmit line in the spacing state for the duration d.
+func (p *Port) SendBreak(d time.Duration) error {
+	r, _, err := syscall.Syscall(nSetCommBreak, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	time.Sleep(d)
+	r, _, err = syscall.Syscall(nClearCommBreak, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// WaitModemChange blocks until one of the CTS, DSR, DCD or RI lines changes
+// state. It fails once the port is closed.
+func (p *Port) WaitModemChange() error {
+	for {
+		if err := resetEvent(p.mo.HEvent); err != nil {
+			return err
+		}
+		r, _, err := syscall.Syscall(nWaitCommEvent, 3, uintptr(p.fd), uintptr(unsafe.Pointer(&p.me)), uintptr(unsafe.Pointer(p.mo)))
+		if r == 0 && err != syscall.ERROR_IO_PENDING {
+			return err
+		}
+		if _, err := getOverlappedResult(p.fd, p.mo); err != nil {
+			return err
+		}
+		if p.me == 0 {
+			// The mask was cleared by Close
+			return os.ErrClosed
+		}
+		if p.me&evModem != 0 {
+			return nil
+		}
+	}
 }
 
 var (
@@ -142,7 +270,13 @@ var (
 	nCreateEvent,
 	nResetEvent,
 	nPurgeComm,
-	nFlushFileBuffers uintptr
+	nFlushFileBuffers,
+	nEscapeCommFunction,
+	nGetCommModemStatus,
+	nWaitCommEvent,
+	nSetCommBreak,
+	nClearCommBreak,
+	nClearCommError uintptr
 )
 
 func init() {
@@ -161,6 +295,12 @@ func init() {
 	nResetEvent = getProcAddr(k32, "ResetEvent")
 	nPurgeComm = getProcAddr(k32, "PurgeComm")
 	nFlushFileBuffers = getProcAddr(k32, "FlushFileBuffers")
+	nEscapeCommFunction = getProcAddr(k32, "EscapeCommFunction")
+	nGetCommModemStatus = getProcAddr(k32, "GetCommModemStatus")
+	nWaitCommEvent = getProcAddr(k32, "WaitCommEvent")
+	nSetCommBreak = getProcAddr(k32, "SetCommBreak")
+	nClearCommBreak = getProcAddr(k32, "ClearCommBreak")
+	nClearCommError = getProcAddr(k32, "ClearCommError")
 }
 
 func getProcAddr(lib syscall.Handle, name string) uintptr {
@@ -171,15 +311,71 @@ func getProcAddr(lib syscall.Handle, name string) uintptr {
 	return addr
 }
 
-func setCommState(h syscall.Handle, baud int) error {
+func setCommState(h syscall.Handle, c *Config) error {
 	var params structDCB
 	params.DCBlength = uint32(unsafe.Sizeof(params))
 
 	params.flags[0] = 0x01  // fBinary
 	params.flags[0] |= 0x10 // Assert DSR
 
-	params.BaudRate = uint32(baud)
-	params.ByteSize = 8
+	params.BaudRate = uint32(c.Baud)
+	params.ByteSize = c.Size
+
+	switch c.Parity {
+	case ParityNone:
+		params.Parity = 0 // NOPARITY
+	case ParityOdd:
+		params.Parity = 1 // ODDPARITY
+	case ParityEven:
+		params.Parity = 2 // EVENPARITY
+	case ParityMark:
+		params.Parity = 3 // MARKPARITY
+	case ParitySpace:
+		params.Parity = 4 // SPACEPARITY
+	default:
+		return ErrBadParity
+	}
+	if c.Parity != ParityNone {
+		params.flags[0] |= 0x02 // fParity
+	}
+
+	// The UART only supports 1.5 stop bits with 5 data bits
+	// and 2 stop bits with 6 to 8 data bits.
+	switch c.StopBits {
+	case Stop1:
+		params.StopBits = 0 // ONESTOPBIT
+	case Stop1Half:
+		if c.Size != 5 {
+			return ErrBadStopBits
+		}
+		params.StopBits = 1 // ONE5STOPBITS
+	case Stop2:
+		if c.Size == 5 {
+			return ErrBadStopBits
+		}
+		params.StopBits = 2 // TWOSTOPBITS
+	default:
+		return ErrBadStopBits
+	}
+
+	switch c.Flow {
+	case FlowNone:
+	case FlowHardware:
+		params.flags[0] |= 0x04 // fOutxCtsFlow
+		params.flags[1] |= 0x20 // fRtsControl = RTS_CONTROL_HANDSHAKE
+	case FlowSoftware:
+		params.flags[1] |= 0x01 // fOutX
+		params.flags[1] |= 0x02 // fInX
+		params.XonChar = c.XonChar
+		params.XoffChar = c.XoffChar
+	default:
+		return ErrBadFlow
+	}
+	if c.Flow != FlowNone {
+		// Thresholds of the 64 bytes input queue requested by setupComm
+		params.XonLim = 16
+		params.XoffLim = 16
+	}
 
 	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(h), uintptr(unsafe.Pointer(&params)), 0)
 	if r == 0 {
@@ -188,22 +384,37 @@ func setCommState(h syscall.Handle, baud int) error {
 	return nil
 }
 
-func setCommTimeouts(h syscall.Handle, readTimeout time.Duration) error {
+func setCommTimeouts(h syscall.Handle, readTimeout, interByteTimeout time.Duration) error {
 	var timeouts structTimeouts
 	const MAXDWORD = 1<<32 - 1
 
-	if readTimeout > 0 {
-		// non-blocking read
-		timeoutMs := readTimeout.Nanoseconds() / 1e6
-		if timeoutMs < 1 {
-			timeoutMs = 1
-		} else if timeoutMs > MAXDWORD {
-			timeoutMs = MAXDWORD
+	// Timeouts are given in milliseconds, MAXDWORD having a special meaning
+	ms := func(d time.Duration) uint32 {
+		if d <= 0 {
+			return 0
+		}
+		t := d.Milliseconds()
+		if t < 1 {
+			t = 1
+		} else if t > MAXDWORD-1 {
+			t = MAXDWORD - 1
 		}
-		timeouts.ReadIntervalTimeout = 0
+		return uint32(t)
+	}
+
+	switch {
+	case interByteTimeout > 0:
+		// read until the line is idle for the interval, or for the
+		// total timeout; no total timeout when it is zero
+		timeouts.ReadIntervalTimeout = ms(interByteTimeout)
 		timeouts.ReadTotalTimeoutMultiplier = 0
-		timeouts.ReadTotalTimeoutConstant = uint32(timeoutMs)
-	} else {
+		timeouts.ReadTotalTimeoutConstant = ms(readTimeout)
+	case readTimeout > 0:
+		// non-blocking read, returns as soon as bytes are received
+		timeouts.ReadIntervalTimeout = MAXDWORD
+		timeouts.ReadTotalTimeoutMultiplier = MAXDWORD
+		timeouts.ReadTotalTimeoutConstant = ms(readTimeout)
+	default:
 		// blocking read
 		timeouts.ReadIntervalTimeout = MAXDWORD
 		timeouts.ReadTotalTimeoutMultiplier = MAXDWORD
@@ -230,6 +441,10 @@ func setCommTimeouts(h syscall.Handle, readTimeout time.Duration) error {
 
 		 If no bytes arrive within the time specified by
 		       ReadTotalTimeoutConstant, ReadFile times out.
+
+		 A value of zero for both ReadTotalTimeoutMultiplier and
+		 ReadTotalTimeoutConstant indicates that total time-outs are
+		 not used for read operations.
 	*/
 
 	r, _, err := syscall.Syscall(nSetCommTimeouts, 2, uintptr(h), uintptr(unsafe.Pointer(&timeouts)), 0)
@@ -247,9 +462,11 @@ func setupComm(h syscall.Handle, in, out int) error {
 	return nil
 }
 
-func setCommMask(h syscall.Handle) error {
-	const EV_RXCHAR = 0x0001
-	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(h), EV_RXCHAR, 0)
+// Events of the communication mask reporting modem status changes.
+const evModem = 0x0008 | 0x0010 | 0x0020 | 0x0100 // EV_CTS | EV_DSR | EV_RLSD | EV_RING
+
+func setCommMask(h syscall.Handle, mask uintptr) error {
+	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(h), mask, 0)
 	if r == 0 {
 		return err
 	}
@@ -264,19 +481,48 @@ func resetEvent(h syscall.Handle) error {
 	return nil
 }
 
-func purgeComm(h syscall.Handle) error {
-	const PURGE_TXABORT = 0x0001
-	const PURGE_RXABORT = 0x0002
-	const PURGE_TXCLEAR = 0x0004
-	const PURGE_RXCLEAR = 0x0008
-	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(h),
-		PURGE_TXABORT|PURGE_RXABORT|PURGE_TXCLEAR|PURGE_RXCLEAR, 0)
+// Flags of purgeComm
+const (
+	purgeTx = 0x0001 | 0x0004 // PURGE_TXABORT | PURGE_TXCLEAR
+	purgeRx = 0x0002 | 0x0008 // PURGE_RXABORT | PURGE_RXCLEAR
+)
+
+func purgeComm(h syscall.Handle, flags uintptr) error {
+	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(h), flags, 0)
 	if r == 0 {
 		return err
 	}
 	return nil
 }
 
+func escapeCommFunction(h syscall.Handle, function uintptr) error {
+	r, _, err := syscall.Syscall(nEscapeCommFunction, 2, uintptr(h), function, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// clearCommError clears the communication error flags and reports a received
+// break or framing / parity error.
+func clearCommError(h syscall.Handle) error {
+	const CE_RXPARITY = 0x0004
+	const CE_FRAME = 0x0008
+	const CE_BREAK = 0x0010
+	var flags uint32
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(h), uintptr(unsafe.Pointer(&flags)), 0)
+	if r == 0 {
+		return err
+	}
+	switch {
+	case flags&CE_BREAK != 0:
+		return ErrBreak
+	case flags&(CE_FRAME|CE_RXPARITY) != 0:
+		return ErrFraming
+	}
+	return nil
+}
+
 func newOverlapped() (*syscall.Overlapped, error) {
 	var overlapped syscall.Overlapped
 	r, _, err := syscall.Syscall6(nCreateEvent, 4, 0, 1, 0, 0, 0, 0)
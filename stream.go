@@ -0,0 +1,70 @@
+package serial
+
+import (
+	"io"
+	"time"
+)
+
+// Reader returns an io.Reader reading the serial buffer, for use with bufio,
+// io.Copy or encoding/binary. Read blocks until some data is received and
+// returns ErrTimeout if none is received within the read timeout. Once the
+// port is closed, Read returns the data left in the buffer, then io.EOF.
+//
+// The data is taken from the buffer also used by ReadLine and the other
+// reading methods, mixing them is not recommended.
+func (sp *SerialPort) Reader() io.Reader {
+	return stream{sp}
+}
+
+// ReadWriteCloser returns an io.ReadWriteCloser reading like Reader, writing
+// like Write and closing the serial port.
+func (sp *SerialPort) ReadWriteCloser() io.ReadWriteCloser {
+	return stream{sp}
+}
+
+// stream adapts a SerialPort to the io interfaces.
+type stream struct {
+	sp *SerialPort
+}
+
+func (s stream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var expired <-chan time.Time
+	for {
+		s.sp.mu.Lock()
+		open, buff, done, timeout := s.sp.portIsOpen, s.sp.buff, s.sp.done, s.sp.readTimeout
+		s.sp.mu.Unlock()
+		if buff == nil {
+			return 0, io.EOF
+		}
+		ready := buff.wait()
+		if n := buff.read(p); n > 0 {
+			return n, nil
+		}
+		if !open {
+			return 0, io.EOF
+		}
+		if expired == nil && timeout > 0 {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			expired = t.C
+		}
+
+		select {
+		case <-ready:
+		case <-done:
+		case <-expired:
+			return 0, ErrTimeout
+		}
+	}
+}
+
+func (s stream) Write(p []byte) (int, error) {
+	return s.sp.Write(p)
+}
+
+func (s stream) Close() error {
+	return s.sp.Close()
+}
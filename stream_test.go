@@ -0,0 +1,59 @@
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/argandas/serial/mock"
+)
+
+func TestReadWriteCloser(t *testing.T) {
+	dev := mock.New()
+	dev.Expect("AT+READ\r\n").Reply("+READ: 42\r\n\x01\x02\x03\x04")
+
+	sp := newTestSerialPort()
+	if err := sp.OpenWith("modem", dev); err != nil {
+		t.Fatal(err)
+	}
+	rwc := sp.ReadWriteCloser()
+	if _, err := io.WriteString(rwc, "AT+READ\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(rwc)
+	if line, err := r.ReadString('\n'); err != nil || line != "+READ: 42\r\n" {
+		t.Errorf("ReadString() = %q, %v", line, err)
+	}
+	var v uint32
+	if err := binary.Read(r, binary.BigEndian, &v); err != nil || v != 0x01020304 {
+		t.Errorf("binary.Read() = %#x, %v", v, err)
+	}
+
+	sp.readTimeout = 10 * time.Millisecond
+	if n, err := rwc.Read(make([]byte, 4)); n != 0 || err != ErrTimeout {
+		t.Errorf("Read() = %d, %v, want ErrTimeout", n, err)
+	}
+
+	// Pending reads end with the port
+	errs := make(chan error)
+	sp.readTimeout = time.Minute
+	go func() {
+		_, err := sp.Reader().Read(make([]byte, 4))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := rwc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != io.EOF {
+			t.Errorf("Read() = %v, want io.EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock Read")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !ppc64 && !ppc64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!ppc64,!ppc64le
+
+package serial
+
+// Termios flags and requests missing from the syscall package, as defined by
+// the asm-generic kernel headers.
+const (
+	cmspar  = 0x40000000 // mark or space (stick) parity
+	crtscts = 0x80000000 // RTS/CTS flow control
+	cbaud   = 0x0000100F // baud rate mask of c_cflag
+	bother  = 0x00001000 // baud rate taken from c_ispeed / c_ospeed
+
+	tcgets2 = 0x802C542A
+	tcsets2 = 0x402C542B
+	tcsbrk  = 0x5409
+	tcflsh  = 0x540B
+)
+
+// termios2 mirrors the kernel struct termios2 used by TCGETS2 / TCSETS2.
+type termios2 struct {
+	Iflag  uint32
+	Oflag  uint32
+	Cflag  uint32
+	Lflag  uint32
+	Line   uint8
+	Cc     [19]uint8
+	Ispeed uint32
+	Ospeed uint32
+}
@@ -0,0 +1,32 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+package serial
+
+// Termios flags and requests missing from the syscall package, as defined by
+// the powerpc kernel headers. powerpc has no TCGETS2 / TCSETS2: its struct
+// termios already carries the speeds.
+const (
+	cmspar  = 0x40000000 // mark or space (stick) parity
+	crtscts = 0x80000000 // RTS/CTS flow control
+	cbaud   = 0x000000FF // baud rate mask of c_cflag
+	bother  = 0x0000001F // baud rate taken from c_ispeed / c_ospeed
+
+	tcgets2 = 0x402C7413 // TCGETS
+	tcsets2 = 0x802C7414 // TCSETS
+	tcsbrk  = 0x2000741D
+	tcflsh  = 0x2000741F
+)
+
+// termios2 mirrors the kernel struct termios, used in place of termios2.
+type termios2 struct {
+	Iflag  uint32
+	Oflag  uint32
+	Cflag  uint32
+	Lflag  uint32
+	Cc     [19]uint8
+	Line   uint8
+	Ispeed uint32
+	Ospeed uint32
+}
@@ -0,0 +1,147 @@
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchEventType identifies the kind of a WatchEvent.
+type WatchEventType int
+
+const (
+	PortAdded   WatchEventType = iota // A serial device appeared
+	PortRemoved                       // A serial device disappeared
+)
+
+func (t WatchEventType) String() string {
+	switch t {
+	case PortAdded:
+		return "added"
+	case PortRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// WatchEvent is delivered by a Watcher when a serial device is plugged or unplugged.
+type WatchEvent struct {
+	Type WatchEventType
+	Port PortInfo
+}
+
+// Watcher reports the serial devices being added and removed, see NewWatcher.
+type Watcher struct {
+	Events chan WatchEvent
+	Errors chan error
+
+	list      func() ([]PortInfo, error)
+	interval  time.Duration
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewWatcher starts watching the serial devices by listing them with ListPorts
+// every interval (one second if interval is zero).
+//
+// A PortAdded event is first delivered for every device already present. A
+// device replaced by another one under the same name is reported as removed
+// then added. Events must be consumed, the watcher does not drop them.
+func NewWatcher(interval time.Duration) (*Watcher, error) {
+	return newWatcher(interval, ListPorts)
+}
+
+func newWatcher(interval time.Duration, list func() ([]PortInfo, error)) (*Watcher, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ports, err := list()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		Events:   make(chan WatchEvent, 16),
+		Errors:   make(chan error, 1),
+		list:     list,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run(ports)
+	return w, nil
+}
+
+// Close stops the watcher and closes the Events and Errors channels.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.wg.Wait()
+		close(w.Events)
+		close(w.Errors)
+	})
+	return nil
+}
+
+func (w *Watcher) run(ports []PortInfo) {
+	defer w.wg.Done()
+	known := make(map[string]PortInfo)
+	if !w.update(known, ports) {
+		return
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		ports, err := w.list()
+		if err != nil {
+			select {
+			case w.Errors <- err:
+			default:
+			}
+			continue
+		}
+		if !w.update(known, ports) {
+			return
+		}
+	}
+}
+
+// update delivers the differences between the known ports and ports, then
+// records ports as known. It returns false if the watcher was closed meanwhile.
+func (w *Watcher) update(known map[string]PortInfo, ports []PortInfo) bool {
+	current := make(map[string]PortInfo, len(ports))
+	for _, p := range ports {
+		current[p.Name] = p
+	}
+	for name, p := range known {
+		if c, ok := current[name]; !ok || c != p {
+			if !w.send(WatchEvent{Type: PortRemoved, Port: p}) {
+				return false
+			}
+			delete(known, name)
+		}
+	}
+	for _, p := range ports {
+		if _, ok := known[p.Name]; ok {
+			continue
+		}
+		if !w.send(WatchEvent{Type: PortAdded, Port: p}) {
+			return false
+		}
+		known[p.Name] = p
+	}
+	return true
+}
+
+func (w *Watcher) send(e WatchEvent) bool {
+	select {
+	case w.Events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}